@@ -1,30 +1,69 @@
 package analyzer
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"log"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/cloudtrail"
-	"github.com/dtylman/korra/analyzer/assumerole"
+	"github.com/aws/aws-sdk-go/service/cloudtrail/cloudtrailiface"
+	"github.com/aws/aws-sdk-go/service/sts"
 	cloudtrailevents "github.com/dtylman/korra/analyzer/cloudtrail"
 )
 
-//Options global options
-var Options struct {
+//Options engine options
+type Options struct {
 	//AccessKey AWS access key
 	AccessKey string
 	//Secret AWS secret
 	Secret string
 	//SessionToken AWS session token
 	SessionToken string
+	//Profile is a named profile from the shared AWS config and credentials files
+	Profile string
+	//RoleARN if set, is assumed with the credentials of the profile or access key
+	RoleARN string
+	//ExternalID is the external ID required to assume RoleARN
+	ExternalID string
+	//MFASerial is the serial number or ARN of the MFA device required to assume RoleARN or the profile role
+	MFASerial string
+	//MFAToken is the current MFA token code
+	MFAToken string
 	//Region os AWS region
 	Region string
 	//MaxOnlineEvents is the maximal number of events to load from cloudtrail
 	MaxOnlineEvents int
+	//RequestsPerSecond is the LookupEvents budget in every account and region (DefaultRequestsPerSecond if zero)
+	RequestsPerSecond float64
+	//Endpoint overrides the endpoint of all AWS services (e.g. a local CloudTrail, STS or S3 stand-in)
+	Endpoint string
+	//Bucket is the S3 bucket holding the trail log files, if set events are loaded from S3
+	Bucket string
+	//Prefix is the trail prefix inside the bucket
+	Prefix string
+	//Accounts lists the account IDs to load from, accounts other than the caller's are reached by
+	//assuming AuditRole (caller account if empty). For S3 it limits loading to these accounts (all if empty).
+	Accounts []string
+	//AuditRole is the name of the role assumed in every account of Accounts
+	AuditRole string
+	//Regions lists the regions to load from, or AllRegions for every enabled region (Region if empty).
+	//For S3 it limits loading to these regions (all if empty).
+	Regions []string
+	//StartTime if set, events before it are not loaded
+	StartTime time.Time
+	//EndTime if set, events after it are not loaded
+	EndTime time.Time
+	//FullReload discards all loaded events and checkpoints before loading
+	FullReload bool
+	//Attributes filters loaded events by LookupEvents attributes (see LookupAttributes)
+	Attributes map[string]string
 }
 
 //ProgressFunc defines a function for progress indication
@@ -32,143 +71,331 @@ type ProgressFunc func(value int, total int)
 
 //Analyzer something that analyzes events
 type Analyzer interface {
-	Analyze(event cloudtrailevents.Event) error
+	Analyze(ctx context.Context, event cloudtrailevents.Event) error
 	Name() string
 	Clear() error
 }
 
-//Analyzers list of analyzers
-var Analyzers []Analyzer
+//NewSession creates new AWS session, with the access key or profile credentials (the default
+//credential chain if neither is set), assuming RoleARN if set
+func (o *Options) NewSession() (*session.Session, error) {
+	log.Println("Creating AWS session...")
+	conf := aws.Config{
+		Region: aws.String(o.Region),
+	}
 
-//AddAnalyzer adds an analyzer
-func AddAnalyzer(a Analyzer) {
-	if Analyzers == nil {
-		Analyzers = make([]Analyzer, 0)
+	if o.Endpoint != "" {
+		conf.Endpoint = aws.String(o.Endpoint)
+		conf.S3ForcePathStyle = aws.Bool(true)
 	}
-	Analyzers = append(Analyzers, a)
-}
 
-//Clear resets analyzer
-func Clear() {
-	cloudtrailevents.Clear()
-	assumerole.Clear()
-	for _, a := range Analyzers {
-		err := a.Clear()
-		if err != nil {
-			log.Println(err)
-		}
+	if o.AccessKey != "" {
+		conf.Credentials = credentials.NewStaticCredentials(o.AccessKey, o.Secret, o.SessionToken)
+	}
+
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Config:                  conf,
+		Profile:                 o.Profile,
+		SharedConfigState:       session.SharedConfigEnable,
+		AssumeRoleTokenProvider: o.mfaToken,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if o.RoleARN == "" {
+		return sess, nil
 	}
+	log.Printf("Assuming %v...", o.RoleARN)
+	creds := stscreds.NewCredentials(sess, o.RoleARN, func(p *stscreds.AssumeRoleProvider) {
+		if o.ExternalID != "" {
+			p.ExternalID = aws.String(o.ExternalID)
+		}
+		if o.MFASerial != "" {
+			p.SerialNumber = aws.String(o.MFASerial)
+			p.TokenProvider = o.mfaToken
+		}
+	})
+	return sess.Copy(&aws.Config{Credentials: creds}), nil
 }
 
-//NewSession creates new AWS session
-func NewSession() (*session.Session, error) {
-	log.Println("Creating AWS session...")
-	conf := &aws.Config{
-		Region: aws.String(Options.Region),
+//mfaToken provides the MFA token code for role assumption
+func (o *Options) mfaToken() (string, error) {
+	if o.MFAToken == "" {
+		return "", errors.New("An MFA token code is required to assume the role")
 	}
+	return o.MFAToken, nil
+}
 
-	if Options.AccessKey != "" {
-		conf.Credentials = credentials.NewStaticCredentials(Options.AccessKey, Options.Secret, Options.SessionToken)
+//inTimeRange returns true if t is within StartTime and EndTime
+func (o *Options) inTimeRange(t time.Time) bool {
+	if !o.StartTime.IsZero() && t.Before(o.StartTime) {
+		return false
+	}
+	if !o.EndTime.IsZero() && t.After(o.EndTime) {
+		return false
 	}
+	return true
+}
 
-	return session.NewSession(conf)
+//callerAccount returns the account ID of the session credentials
+func callerAccount(ctx context.Context, sess *session.Session) (string, error) {
+	out, err := sts.New(sess).GetCallerIdentityWithContext(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		return "", err
+	}
+	return aws.StringValue(out.Account), nil
 }
 
-//Load reads all events from cloudtrail and builds assumerole sessions
-func Load(progress ProgressFunc) error {
-	Clear()
-	sess, err := NewSession()
+//load reads events from cloudtrail in every account and region of the options,
+//newer than the account and region checkpoint
+func (e *Engine) load(ctx context.Context, b *batch, progress ProgressFunc) error {
+	opts := e.Options()
+	sess, err := opts.NewSession()
+	if err != nil {
+		return err
+	}
+	caller, err := callerAccount(ctx, sess)
 	if err != nil {
 		return err
 	}
-	svc := cloudtrail.New(sess)
+	targets, sessions := plan(ctx, sess, &opts, caller)
+	e.setTargets(targets)
+	log.Printf("Loading from %v accounts and regions", len(targets))
 
-	input := &cloudtrail.LookupEventsInput{
-		MaxResults: aws.Int64(50),
-		EndTime:    aws.Time(time.Now())}
+	tp := newTargetsProgress(len(targets), progress)
+	limit := make(chan bool, maxParallelTargets)
+	var wg sync.WaitGroup
+	for i := range targets {
+		if targets[i].Done {
+			tp.done(i)
+			continue
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			limit <- true
+			defer func() { <-limit }()
+			t := targets[i]
+			tb := e.newBatch()
+			err := e.loadTarget(ctx, cloudtrail.New(sessions[i]), &opts, &t, tb, tp.target(i))
+			if err != nil {
+				log.Printf("%v: %v", t, err)
+				t.Error = err.Error()
+			}
+			t.Done = true
+			e.setTarget(i, t)
+			tp.done(i)
+			b.merge(tb)
+		}(i)
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return targetsError(e.Targets())
+}
 
-	needMore := true
-	total := len(cloudtrailevents.Events)
-	if progress != nil {
-		progress(total, Options.MaxOnlineEvents)
-		defer progress(total, total)
+//loadTarget reads the events of one account and region, newer than its checkpoint, or continues
+//an interrupted load. Events are tagged with the target account and region if they do not carry them.
+//If loading stops at MaxOnlineEvents or fails, the next load resumes from the last page read.
+func (e *Engine) loadTarget(ctx context.Context, svc cloudtrailiface.CloudTrailAPI, opts *Options, t *Target, b *batch, progress ProgressFunc) error {
+	input := &cloudtrail.LookupEventsInput{
+		MaxResults:       aws.Int64(50),
+		EndTime:          aws.Time(time.Now()),
+		LookupAttributes: opts.lookupAttribute()}
+	if !opts.EndTime.IsZero() {
+		input.EndTime = aws.Time(opts.EndTime)
+	}
+	start := e.since(opts, t.Account, t.Region)
+	if !start.IsZero() {
+		input.StartTime = aws.Time(start)
 	}
-	for needMore {
-		resp, err := svc.LookupEvents(input)
+	cp, _ := e.Checkpoints.Get(t.Account, t.Region)
+	resume := cp.Resume
+	if e.partial(opts, t.Account, t.Region) {
+		resume = nil
+	}
+	if resume != nil {
+		log.Printf("%v: resuming interrupted load of %v to %v", t, resume.StartTime, resume.EndTime)
+		input.StartTime = aws.Time(resume.StartTime)
+		input.EndTime = aws.Time(resume.EndTime)
+		input.NextToken = aws.String(resume.NextToken)
+	} else if !start.IsZero() {
+		log.Printf("%v: loading events since %v", t, start)
+	}
+	suspend := func(token string) {
+		b.suspend(opts, t.Account, t.Region, Resume{
+			NextToken: token,
+			StartTime: aws.TimeValue(input.StartTime),
+			EndTime:   aws.TimeValue(input.EndTime),
+		}, resume)
+	}
+
+	pager := newLookupPager(svc, input, opts.RequestsPerSecond)
+	progress(t.Loaded, opts.MaxOnlineEvents)
+	for pager.more() {
+		token := pager.token()
+		events, err := pager.next(ctx)
 		if err != nil {
+			suspend(token)
 			return err
 		}
-		input.NextToken = resp.NextToken
-		if aws.StringValue(resp.NextToken) == "" {
-			needMore = false
-			continue
-		}
-		for _, object := range resp.Events {
-			total = len(cloudtrailevents.Events)
-			if total >= Options.MaxOnlineEvents {
-				needMore = false
-				continue
+		for _, object := range events {
+			if t.Loaded >= opts.MaxOnlineEvents {
+				log.Printf("%v: stopped after %v events, the next load continues from here", t, t.Loaded)
+				suspend(token)
+				return nil
 			}
-			raw := aws.StringValue(object.CloudTrailEvent)
-			var event cloudtrailevents.Event
-			event.RawEvent = raw
-			err := json.Unmarshal([]byte(raw), &event)
+			event, err := cloudtrailevents.NewEvent([]byte(aws.StringValue(object.CloudTrailEvent)))
 			if err != nil {
 				log.Println(err)
-			} else {
-				cloudtrailevents.AddEvent(event)
+				continue
+			}
+			if event.RecipientAccountID == "" {
+				event.RecipientAccountID = t.Account
+			}
+			if event.Region == "" {
+				event.Region = t.Region
+			}
+			if opts.match(event) && b.add(event) {
+				t.Loaded++
 			}
 		}
-		log.Printf("Read %v events", total)
-		if progress != nil {
-			progress(total, Options.MaxOnlineEvents)
-		}
+		log.Printf("%v: read %v new events", t, t.Loaded)
+		progress(t.Loaded, opts.MaxOnlineEvents)
+	}
+	b.commit(opts)
+	if resume != nil {
+		e.Checkpoints.Update(t.Account, t.Region, resume.Newest)
+		e.Checkpoints.SetResume(t.Account, t.Region, nil)
 	}
 	return nil
 }
 
-//Analyze runs analyzers on data
-func Analyze(progress ProgressFunc) error {
+//buildSessions adds assume role events to the sessions
+func (e *Engine) buildSessions(events eventSource) {
+	err := events.Each(func(event cloudtrailevents.Event) error {
+		err := e.Sessions.AddEvent(event)
+		if err != nil {
+			log.Println(err)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+//analyze runs analyzers and hunts on data
+func (e *Engine) analyze(ctx context.Context, progress ProgressFunc) error {
 	defer log.Println("Done")
-	assumerole.Clear()
-	cloudtrailevents.Sort()
+	e.Sessions.Clear()
+	e.Findings.Clear()
 
-	for _, a := range Analyzers {
+	for _, a := range e.Analyzers() {
 		err := a.Clear()
 		if err != nil {
 			return err
 		}
 	}
-	// build assume role sessions
-	for _, e := range cloudtrailevents.Events {
-		err := assumerole.AddEvent(e)
-		if err != nil {
-			log.Println(err)
-		}
-	}
-	log.Println("Indexing...")
-	total := len(cloudtrailevents.Events)
-	for i, e := range cloudtrailevents.Events {
-		progress(i, total)
-		for _, a := range Analyzers {
-			err := a.Analyze(e)
-			if err != nil {
-				log.Printf("%v: %v", a.Name(), err)
-			}
-		}
+	e.buildSessions(e.Events)
+	err := e.run(ctx, e.Events, progress)
+	if err != nil {
+		return err
 	}
-	return nil
+	return e.runHunts(ctx)
 }
 
-//LoadAndAnalyze resets analyzer, loads new data and perform all analysis
-func LoadAndAnalyze(progress ProgressFunc) error {
-	err := Load(progress)
+//analyzeNew adds new events to the sessions and runs the analyzers on them only, hunts run on all events
+func (e *Engine) analyzeNew(ctx context.Context, events []cloudtrailevents.Event, progress ProgressFunc) error {
+	defer log.Println("Done")
+	sort.Sort(cloudtrailevents.ByTime(events))
+	e.buildSessions(eventSlice(events))
+	err := e.run(ctx, eventSlice(events), progress)
 	if err != nil {
 		return err
 	}
-	err = Analyze(progress)
-	if err != nil {
-		return err
+	return e.runHunts(ctx)
+}
+
+//fetch loads new events according to the options
+func (e *Engine) fetch(ctx context.Context, progress ProgressFunc) (*batch, error) {
+	opts := e.Options()
+	if opts.FullReload {
+		e.clear()
+	}
+	e.setTargets(nil)
+	b := e.newBatch()
+	var err error
+	if opts.Bucket != "" {
+		err = e.loadFromS3(ctx, b, progress)
+	} else {
+		err = e.load(ctx, b, progress)
+	}
+	return b, err
+}
+
+//Load reads events from cloudtrail, only events newer than the last load are read unless
+//Options.FullReload is set. Events read before ctx is cancelled are kept.
+func (e *Engine) Load(ctx context.Context, progress ProgressFunc) error {
+	e.running.Lock()
+	defer e.running.Unlock()
+	_, err := e.fetch(ctx, progress)
+	return err
+}
+
+//Analyze runs analyzers and hunts on data, until ctx is cancelled
+func (e *Engine) Analyze(ctx context.Context, progress ProgressFunc) error {
+	e.running.Lock()
+	defer e.running.Unlock()
+	return e.analyze(ctx, progress)
+}
+
+//LoadAndAnalyze loads new events and analyzes them, if Options.FullReload is set
+//the engine is reset and all events are loaded and analyzed. Events loaded and findings
+//reported before an error or cancellation are saved too.
+func (e *Engine) LoadAndAnalyze(ctx context.Context, progress ProgressFunc) error {
+	e.running.Lock()
+	defer e.running.Unlock()
+	b, err := e.fetch(ctx, progress)
+	log.Printf("Loaded %v new events", len(b.events))
+	var aerr error
+	if e.Options().FullReload {
+		aerr = e.analyze(ctx, progress)
+	} else {
+		aerr = e.analyzeNew(ctx, b.events, progress)
+	}
+	return e.saveAfter(err, aerr)
+}
+
+//ImportAndAnalyze adds events from local CloudTrail files or archives and performs all analysis.
+//Events imported and findings reported before an error or cancellation are saved too, events
+//imported from the other files are analyzed if some files cannot be read.
+func (e *Engine) ImportAndAnalyze(ctx context.Context, path string, progress ProgressFunc) error {
+	e.running.Lock()
+	defer e.running.Unlock()
+	log.Printf("Importing %v...", path)
+	added, err := e.Events.Import(ctx, path, progress)
+	log.Printf("Imported %v new events", added)
+	var aerr error
+	if err == nil || (added > 0 && ctx.Err() == nil) {
+		aerr = e.analyze(ctx, progress)
+	}
+	return e.saveAfter(err, aerr)
+}
+
+//saveAfter saves the engine after a load or import and its analysis, even if either failed
+//or was cancelled, and returns the first error
+func (e *Engine) saveAfter(loadErr error, analyzeErr error) error {
+	if loadErr == context.Canceled || analyzeErr == context.Canceled {
+		log.Println("Cancelled, analyze again to include all loaded events")
+	}
+	err := e.Save()
+	if loadErr != nil {
+		return loadErr
+	}
+	if analyzeErr != nil {
+		return analyzeErr
 	}
-	return cloudtrailevents.SaveToFile()
+	return err
 }
@@ -0,0 +1,49 @@
+package analyzer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOptions_NewSession(t *testing.T) {
+	dir, err := ioutil.TempDir("", "korra-aws")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	config := filepath.Join(dir, "config")
+	assert.NoError(t, ioutil.WriteFile(config, []byte("[profile audit]\nregion = eu-west-1\naws_access_key_id = AKIDPROFILE\naws_secret_access_key = secret\n"), 0600))
+	os.Setenv("AWS_CONFIG_FILE", config)
+	os.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "credentials"))
+	defer os.Unsetenv("AWS_CONFIG_FILE")
+	defer os.Unsetenv("AWS_SHARED_CREDENTIALS_FILE")
+
+	opts := Options{Region: "us-east-1", AccessKey: "AKIDSTATIC", Secret: "secret"}
+	sess, err := opts.NewSession()
+	assert.NoError(t, err)
+	creds, err := sess.Config.Credentials.Get()
+	assert.NoError(t, err)
+	assert.EqualValues(t, "AKIDSTATIC", creds.AccessKeyID)
+
+	opts = Options{Profile: "audit"}
+	sess, err = opts.NewSession()
+	assert.NoError(t, err)
+	creds, err = sess.Config.Credentials.Get()
+	assert.NoError(t, err)
+	assert.EqualValues(t, "AKIDPROFILE", creds.AccessKeyID)
+	assert.EqualValues(t, "eu-west-1", *sess.Config.Region)
+
+	opts = Options{Profile: "missing"}
+	sess, err = opts.NewSession()
+	assert.NoError(t, err)
+	_, err = sess.Config.Credentials.Get()
+	assert.Error(t, err)
+
+	opts = Options{Region: "us-east-1", AccessKey: "AKIDSTATIC", Secret: "secret", RoleARN: "arn:aws:iam::123:role/audit", MFASerial: "arn:aws:iam::123:mfa/user"}
+	sess, err = opts.NewSession()
+	assert.NoError(t, err)
+	_, err = sess.Config.Credentials.Get()
+	assert.Error(t, err)
+}
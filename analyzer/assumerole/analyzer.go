@@ -1,23 +1,49 @@
 package assumerole
 
 import (
+	"context"
+	"fmt"
+
 	"github.com/dtylman/korra/analyzer/cloudtrail"
+	"github.com/dtylman/korra/analyzer/findings"
 )
 
+//RuleUnassignedIP is reported when an assumed role is used from an IP address it was not assumed from
+const RuleUnassignedIP = "assumerole/unassigned-ip"
+
 //SessionAnalyzer ...
 type SessionAnalyzer struct {
+	sessions *Sessions
+	reporter findings.Reporter
+}
+
+//NewSessionAnalyzer creates an analyzer that reports session findings to reporter
+func NewSessionAnalyzer(sessions *Sessions, reporter findings.Reporter) *SessionAnalyzer {
+	return &SessionAnalyzer{sessions: sessions, reporter: reporter}
 }
 
 //Analyze ...
-func (sa *SessionAnalyzer) Analyze(e cloudtrail.Event) error {
-	sess, ok := Sessions[e.UserIdentity.ARN]
+func (sa *SessionAnalyzer) Analyze(ctx context.Context, e cloudtrail.Event) error {
+	sess, ok := sa.sessions.Issuer(e)
 	if ok {
 		if !sess.HasSourceIP(e.SourceIPAddress) {
-			sess.AddIssue("", "ARN '%v' used from an IP address '%v' but was never assigned to. User: '%v', User agent: '%v'",
-				e.UserIdentity.ARN,
-				e.SourceIPAddress,
-				e.UserIdentity.UserName,
-				e.UserAgent)
+			sa.reporter.Report(findings.Finding{
+				RuleID:     RuleUnassignedIP,
+				Title:      fmt.Sprintf("Assumed role used from IP address '%v' it was never assigned to", e.SourceIPAddress),
+				Severity:   findings.SeverityMedium,
+				Principal:  e.UserIdentity.ARN,
+				SessionARN: sess.AssumedRoleARN,
+				EventIDs:   []string{e.ID},
+				FirstSeen:  e.Time,
+				LastSeen:   e.Time,
+				Evidence: map[string]string{
+					"sourceIPAddress":  e.SourceIPAddress,
+					"userAgent":        e.UserAgent,
+					"userName":         e.UserIdentity.UserName,
+					"session":          sess.Name,
+					"mfaAuthenticated": fmt.Sprintf("%v", e.UserIdentity.MFA()),
+				},
+			})
 		}
 	}
 	return nil
@@ -2,43 +2,96 @@ package assumerole
 
 import (
 	"fmt"
+	"sync"
 
 	"github.com/dtylman/korra/analyzer/cloudtrail"
 )
 
-//Sessions holds a map off assume roles by arn
-var Sessions map[string]Session
+//Sessions holds a map off assume roles by arn, it is safe for concurrent use
+type Sessions struct {
+	mutex    sync.RWMutex
+	sessions map[string]Session
+	//keys maps the access keys issued by AssumeRole to the assumed role arn
+	keys map[string]string
+}
+
+//NewSessions creates an empty sessions list
+func NewSessions() *Sessions {
+	return &Sessions{sessions: make(map[string]Session), keys: make(map[string]string)}
+}
 
 //AddEvent adds an event to the sessions
-func AddEvent(e cloudtrail.Event) error {
+func (s *Sessions) AddEvent(e cloudtrail.Event) error {
 	if e.Name != "AssumeRole" {
 		return nil
 	}
 	if e.HasError() {
 		return nil
 	}
-	if e.Name == "AssumeRole" {
-		arn := e.BuildAssumedRoleARN()
-		if arn == "" {
-			return fmt.Errorf("Cannot get AssumeRoleARN for event: %v", e)
-		}
-		sess, ok := Sessions[arn]
-		if ok {
-			sess.AddEvent(e)
-			Sessions[arn] = sess
-		} else {
-			sess = Session{
-				Name:           e.RequestParameters.RoleSessionName,
-				AssumedRoleARN: arn,
-			}
-			sess.AddEvent(e)
-			Sessions[arn] = sess
+	arn := e.BuildAssumedRoleARN()
+	if arn == "" {
+		return fmt.Errorf("Cannot get AssumeRoleARN for event: %v", e)
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	sess, ok := s.sessions[arn]
+	if !ok {
+		sess = Session{
+			Name:           e.RequestParameters.String("roleSessionName"),
+			AssumedRoleARN: arn,
 		}
 	}
+	sess.AddEvent(e)
+	s.sessions[arn] = sess
+	if key := e.ResponseElements.String("credentials", "accessKeyId"); key != "" {
+		s.keys[key] = arn
+	}
 	return nil
 }
 
+//Get returns the session with the given assumed role arn
+func (s *Sessions) Get(arn string) (Session, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	sess, ok := s.sessions[arn]
+	return sess, ok
+}
+
+//Issuer returns the session an event was made in, by the access key the AssumeRole issued,
+//or by the assumed role arn if the access key is unknown
+func (s *Sessions) Issuer(e cloudtrail.Event) (Session, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	arn, ok := s.keys[e.UserIdentity.AccessKeyID]
+	if !ok {
+		arn = e.UserIdentity.ARN
+	}
+	sess, ok := s.sessions[arn]
+	return sess, ok
+}
+
+//List returns all sessions
+func (s *Sessions) List() []Session {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	list := make([]Session, 0, len(s.sessions))
+	for _, sess := range s.sessions {
+		list = append(list, sess)
+	}
+	return list
+}
+
+//Len returns the number of sessions
+func (s *Sessions) Len() int {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return len(s.sessions)
+}
+
 //Clear resets the sessions lists
-func Clear() {
-	Sessions = make(map[string]Session)
+func (s *Sessions) Clear() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.sessions = make(map[string]Session)
+	s.keys = make(map[string]string)
 }
@@ -11,7 +11,6 @@ type Session struct {
 	Name           string
 	AssumedRoleARN string
 	Events         []cloudtrail.Event
-	Issues         []string
 }
 
 //AddEvent adds event to session
@@ -42,7 +41,7 @@ func keysToStr(m map[string]bool) string {
 
 //Users returns all users associated with this session
 func (ars *Session) Users() string {
-	var users map[string]bool
+	users := make(map[string]bool)
 	for _, e := range ars.Events {
 		users[e.UserIdentity.UserName] = true
 	}
@@ -51,22 +50,13 @@ func (ars *Session) Users() string {
 
 //IPs returns all source IP address associated with this session
 func (ars *Session) IPs() string {
-	var ips map[string]bool
+	ips := make(map[string]bool)
 	for _, e := range ars.Events {
 		ips[e.SourceIPAddress] = true
 	}
 	return keysToStr(ips)
 }
 
-//AddIssue adds analyzed issue to the session
-func (ars *Session) AddIssue(severity string, message string, args ...interface{}) {
-	if len(ars.Issues) == 0 {
-		ars.Issues = make([]string, 0)
-	}
-	msg := fmt.Sprintf("%v: %v", severity, fmt.Sprintf(message, args...))
-	ars.Issues = append(ars.Issues, msg)
-}
-
 //Time returne the sessions tarting time
 func (ars *Session) Time() string {
 	if len(ars.Events) == 0 {
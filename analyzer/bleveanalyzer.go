@@ -1,44 +1,154 @@
 package analyzer
 
 import (
+	"context"
+	"log"
 	"os"
+	"runtime"
+	"sync"
 
 	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/document"
 	"github.com/dtylman/korra/analyzer/cloudtrail"
 )
 
+//indexBatchSize is the number of events indexed in a single batch, larger batches are slower as
+//the rows of a batch are written to the index store in a single transaction
+const indexBatchSize = 100
+
 //BleveAnalyzer ...
 type BleveAnalyzer struct {
-	Index bleve.Index
-	path  string
+	mutex sync.RWMutex
+	index bleve.Index
+	//batches holds a batch for every worker, a worker takes one to add an event
+	batches chan *bleve.Batch
+	workers int
+	path    string
+}
+
+//newIndex creates a new index with the CloudTrail events mapping
+func newIndex(path string) (bleve.Index, error) {
+	m, err := newIndexMapping()
+	if err != nil {
+		return nil, err
+	}
+	return bleve.New(path, m)
 }
 
 //NewBleveAnalyzer ...
 func NewBleveAnalyzer(path string) (*BleveAnalyzer, error) {
 	ba := new(BleveAnalyzer)
 	ba.path = path
+	ba.workers = runtime.NumCPU()
 	_, err := os.Stat(ba.path)
 	if err == nil {
-		ba.Index, err = bleve.Open(ba.path)
+		ba.index, err = bleve.Open(ba.path)
 	} else if !os.IsNotExist(err) {
 		return nil, err
 	} else {
-		ba.Index, err = bleve.New(path, bleve.NewIndexMapping())
+		ba.index, err = newIndex(path)
 	}
 	if err != nil {
 		return nil, err
 	}
+	ba.newBatches()
 	return ba, nil
 }
 
-//Analyze ...
-func (ba *BleveAnalyzer) Analyze(e cloudtrail.Event) error {
-	return ba.Index.Index(e.ID, e)
+//newBatches creates the batches of the workers for the current index
+func (ba *BleveAnalyzer) newBatches() {
+	ba.batches = make(chan *bleve.Batch, ba.workers)
+	for i := 0; i < cap(ba.batches); i++ {
+		ba.batches <- ba.index.NewBatch()
+	}
+}
+
+//Workers returns the number of workers events are indexed by, every worker fills its own batch
+func (ba *BleveAnalyzer) Workers() int {
+	return ba.workers
+}
+
+//Analyze adds the event to a batch, the batch is indexed when full or flushed
+func (ba *BleveAnalyzer) Analyze(ctx context.Context, e cloudtrail.Event) error {
+	ba.mutex.RLock()
+	defer ba.mutex.RUnlock()
+	b := <-ba.batches
+	defer func() { ba.batches <- b }()
+	err := b.Index(e.ID, e)
+	if err != nil {
+		return err
+	}
+	if b.Size() >= indexBatchSize {
+		return ba.flush(b)
+	}
+	return nil
+}
+
+//Flush indexes the events of all batches
+func (ba *BleveAnalyzer) Flush() error {
+	ba.mutex.RLock()
+	defer ba.mutex.RUnlock()
+	return ba.flushAll()
+}
+
+//flushAll indexes all batches, waiting for the workers to return them, callers must hold the lock
+func (ba *BleveAnalyzer) flushAll() error {
+	taken := make([]*bleve.Batch, 0, cap(ba.batches))
+	var err error
+	for i := 0; i < cap(ba.batches); i++ {
+		b := <-ba.batches
+		taken = append(taken, b)
+		ferr := ba.flush(b)
+		if err == nil {
+			err = ferr
+		}
+	}
+	for _, b := range taken {
+		ba.batches <- b
+	}
+	return err
+}
+
+//flush indexes a batch
+func (ba *BleveAnalyzer) flush(b *bleve.Batch) error {
+	if b.Size() == 0 {
+		return nil
+	}
+	err := ba.index.Batch(b)
+	b.Reset()
+	return err
+}
+
+//Search runs a search request on the index
+func (ba *BleveAnalyzer) Search(req *bleve.SearchRequest) (*bleve.SearchResult, error) {
+	ba.mutex.RLock()
+	defer ba.mutex.RUnlock()
+	return ba.index.Search(req)
+}
+
+//Count returns the number of indexed events
+func (ba *BleveAnalyzer) Count() (uint64, error) {
+	ba.mutex.RLock()
+	defer ba.mutex.RUnlock()
+	return ba.index.DocCount()
+}
+
+//Document returns the indexed document with the given id
+func (ba *BleveAnalyzer) Document(id string) (*document.Document, error) {
+	ba.mutex.RLock()
+	defer ba.mutex.RUnlock()
+	return ba.index.Document(id)
 }
 
 // Close ...
 func (ba *BleveAnalyzer) Close() error {
-	return ba.Index.Close()
+	ba.mutex.Lock()
+	defer ba.mutex.Unlock()
+	err := ba.flushAll()
+	if err != nil {
+		log.Println(err)
+	}
+	return ba.index.Close()
 }
 
 //Name ...
@@ -48,7 +158,9 @@ func (ba *BleveAnalyzer) Name() string {
 
 //Clear ...
 func (ba *BleveAnalyzer) Clear() error {
-	ba.Close()
+	ba.mutex.Lock()
+	defer ba.mutex.Unlock()
+	ba.index.Close()
 	var err error
 	if ba.path != "" {
 		err = os.RemoveAll(ba.path)
@@ -56,6 +168,10 @@ func (ba *BleveAnalyzer) Clear() error {
 			return err
 		}
 	}
-	ba.Index, err = bleve.New(ba.path, bleve.NewIndexMapping())
-	return err
+	ba.index, err = newIndex(ba.path)
+	if err != nil {
+		return err
+	}
+	ba.newBatches()
+	return nil
 }
@@ -0,0 +1,79 @@
+package analyzer
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/blevesearch/bleve"
+	"github.com/dtylman/korra/analyzer/cloudtrail"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBleveAnalyzer_Mapping(t *testing.T) {
+	e, _, cleanup := newTestEngine(t, "korra.db")
+	defer cleanup()
+	ba := e.Indexer
+
+	assume, err := cloudtrail.NewEvent([]byte(`{"eventID":"1","eventName":"AssumeRole","eventTime":"2018-10-30T10:00:00Z",
+		"sourceIPAddress":"10.0.0.1","userIdentity":{"arn":"arn:aws:iam::123:user/alice","accessKeyId":"AKIDALICE"},
+		"userAgent":"aws-cli/1.16 Python/3.6"}`))
+	assert.NoError(t, err)
+	denied, err := cloudtrail.NewEvent([]byte(`{"eventID":"2","eventName":"GetObject","eventTime":"2018-10-31T10:00:00Z",
+		"sourceIPAddress":"10.0.0.2","errorCode":"AccessDenied","userIdentity":{"arn":"arn:aws:iam::123:user/bob"}}`))
+	assert.NoError(t, err)
+	assert.NoError(t, ba.Analyze(context.Background(), assume))
+	assert.NoError(t, ba.Analyze(context.Background(), denied))
+
+	//batched events are searchable only after a flush
+	count := func(query string) uint64 {
+		sr, err := ba.Search(bleve.NewSearchRequest(bleve.NewQueryStringQuery(query)))
+		assert.NoError(t, err)
+		return sr.Total
+	}
+	assert.EqualValues(t, 0, count("AssumeRole"))
+	assert.NoError(t, ba.Flush())
+
+	assert.EqualValues(t, 1, count("eventName:AssumeRole"))
+	assert.EqualValues(t, 1, count(`userIdentity.arn:"arn:aws:iam::123:user/alice"`))
+	assert.EqualValues(t, 0, count(`userIdentity.arn:alice`))
+	assert.EqualValues(t, 1, count(`sourceIPAddress:"10.0.0.2"`))
+	assert.EqualValues(t, 1, count("userIdentity.accessKeyId:AKIDALICE"))
+	assert.EqualValues(t, 1, count("errorCode:AccessDenied"))
+	assert.EqualValues(t, 1, count("userAgent:python"))
+	assert.EqualValues(t, 0, count("raw:AssumeRole"))
+
+	start := time.Date(2018, 10, 31, 0, 0, 0, 0, time.UTC)
+	sr, err := ba.Search(bleve.NewSearchRequest(bleve.NewDateRangeQuery(start, time.Time{})))
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, sr.Total)
+
+	doc, err := ba.Document("1")
+	assert.NoError(t, err)
+	stored := false
+	for _, f := range doc.Fields {
+		if f.Name() == "raw" {
+			stored = true
+		}
+	}
+	assert.True(t, stored)
+}
+
+func TestBleveAnalyzer_Parallel(t *testing.T) {
+	e, cleanup := newSearchEngine(t)
+	defer cleanup()
+	e.Indexer.workers = 4
+	e.Indexer.newBatches()
+	assert.NoError(t, e.Indexer.Clear())
+
+	events := make([]cloudtrail.Event, 0)
+	for i := 0; i < 10*indexBatchSize+7; i++ {
+		events = append(events, cloudtrail.Event{ID: fmt.Sprintf("%v", i), Name: "GetObject", Time: time.Now()})
+	}
+	assert.NoError(t, e.runAnalyzers(context.Background(), []Analyzer{e.Indexer}, eventSlice(events), nil))
+	count, err := e.Indexer.Count()
+	assert.NoError(t, err)
+	assert.EqualValues(t, len(events), count)
+	assert.EqualValues(t, 4, e.Stats()[0].Workers)
+}
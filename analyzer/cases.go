@@ -0,0 +1,357 @@
+package analyzer
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+//DefaultCase is the case kept in the data directory itself
+const DefaultCase = "default"
+
+//files of a case
+const (
+	caseEventsFile = "korra.events.db"
+	caseIndexFile  = "korra.db"
+	casesDir       = "cases"
+)
+
+//archiveEvents is the event store in a case archive
+const archiveEvents = "events.db"
+
+//archiveFiles maps the other files of a case archive to the suffixes of the engine files
+var archiveFiles = map[string]string{
+	"findings.json":    ".findings.json",
+	"checkpoints.json": ".checkpoints.json",
+	"searches.json":    ".searches.json",
+}
+
+var caseNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+//Cases are named investigations kept in a data directory, each with its own events, search index,
+//sessions, findings and saved searches. The default case is kept in the data directory itself,
+//the others in its cases subdirectory.
+type Cases struct {
+	dir string
+}
+
+//NewCases returns the cases of the data directory dir
+func NewCases(dir string) *Cases {
+	return &Cases{dir: dir}
+}
+
+//Dir returns the directory of a case
+func (c *Cases) Dir(name string) (string, error) {
+	if name == "" || name == DefaultCase {
+		return c.dir, nil
+	}
+	if !caseNamePattern.MatchString(name) {
+		return "", fmt.Errorf("Invalid case name '%v', use letters, digits, '.', '_' and '-'", name)
+	}
+	return filepath.Join(c.dir, casesDir, name), nil
+}
+
+//Exists returns true if the case exists, the default case always exists
+func (c *Cases) Exists(name string) bool {
+	dir, err := c.Dir(name)
+	if err != nil {
+		return false
+	}
+	info, err := os.Stat(dir)
+	return err == nil && info.IsDir()
+}
+
+//List returns the names of the cases, the default case first
+func (c *Cases) List() ([]string, error) {
+	names := make([]string, 0)
+	infos, err := ioutil.ReadDir(filepath.Join(c.dir, casesDir))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, info := range infos {
+		if info.IsDir() && caseNamePattern.MatchString(info.Name()) && info.Name() != DefaultCase {
+			names = append(names, info.Name())
+		}
+	}
+	sort.Strings(names)
+	return append([]string{DefaultCase}, names...), nil
+}
+
+//Create creates an empty case
+func (c *Cases) Create(name string) error {
+	if name == "" {
+		return fmt.Errorf("Case has no name")
+	}
+	dir, err := c.Dir(name)
+	if err != nil {
+		return err
+	}
+	if c.Exists(name) {
+		return fmt.Errorf("Case '%v' already exists", name)
+	}
+	return os.MkdirAll(dir, 0700)
+}
+
+//Delete removes a case and all of its files, the default case cannot be deleted
+func (c *Cases) Delete(name string) error {
+	if name == "" || name == DefaultCase {
+		return fmt.Errorf("The default case cannot be deleted")
+	}
+	if !c.Exists(name) {
+		return fmt.Errorf("Case '%v' not found", name)
+	}
+	dir, err := c.Dir(name)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(dir)
+}
+
+//Open creates the engine of a case, with a search index if index is set
+func (c *Cases) Open(name string, index bool) (*Engine, error) {
+	if !c.Exists(name) {
+		return nil, fmt.Errorf("Case '%v' not found", name)
+	}
+	dir, err := c.Dir(name)
+	if err != nil {
+		return nil, err
+	}
+	indexPath := ""
+	if index {
+		indexPath = filepath.Join(dir, caseIndexFile)
+	}
+	return NewEngine(filepath.Join(dir, caseEventsFile), indexPath)
+}
+
+//CreateFrom creates a case with the events of src matching the search options and analyzes them,
+//returns the number of events copied. The case is removed if it cannot be completed.
+func (c *Cases) CreateFrom(ctx context.Context, name string, src *Engine, opts SearchOptions, progress ProgressFunc) (int, error) {
+	err := c.Create(name)
+	if err != nil {
+		return 0, err
+	}
+	copied, err := c.fill(ctx, name, src, opts, progress)
+	if err != nil {
+		derr := c.Delete(name)
+		if derr != nil {
+			log.Printf("Case '%v': %v", name, derr)
+		}
+	}
+	return copied, err
+}
+
+//fill copies the events of src matching the search options to a new case and analyzes them
+func (c *Cases) fill(ctx context.Context, name string, src *Engine, opts SearchOptions, progress ProgressFunc) (int, error) {
+	dst, err := c.Open(name, true)
+	if err != nil {
+		return 0, err
+	}
+	copied, err := src.copyEvents(ctx, opts, dst)
+	if err == nil {
+		err = dst.Analyze(ctx, progress)
+	}
+	if err == nil {
+		err = dst.Save()
+	}
+	cerr := dst.Close()
+	if err != nil {
+		return copied, err
+	}
+	return copied, cerr
+}
+
+//copyEvents adds the events matching the search options to dst
+func (e *Engine) copyEvents(ctx context.Context, opts SearchOptions, dst *Engine) (int, error) {
+	if e.Indexer == nil {
+		return 0, fmt.Errorf("Engine was created without a search index")
+	}
+	opts.From = 0
+	opts.Size = searchPageSize
+	opts.Sort = SortOldest
+	copied := 0
+	for {
+		if ctx.Err() != nil {
+			return copied, ctx.Err()
+		}
+		req, err := opts.Request()
+		if err != nil {
+			return copied, err
+		}
+		sr, err := e.Indexer.Search(req)
+		if err != nil {
+			return copied, err
+		}
+		for _, hit := range sr.Hits {
+			event, ok := e.Events.Get(hit.ID)
+			if ok && dst.Events.AddNew(event) {
+				copied++
+			}
+		}
+		if len(sr.Hits) < opts.Size {
+			return copied, nil
+		}
+		opts = opts.Next()
+	}
+}
+
+//Export writes the events, findings, checkpoints and saved searches to w as a gzipped tar archive,
+//the search index is not included, it is rebuilt by Cases.Import
+func (e *Engine) Export(w io.Writer) error {
+	e.running.Lock()
+	defer e.running.Unlock()
+	err := e.Save()
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempDir("", "korra-export")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	events := filepath.Join(tmp, archiveEvents)
+	err = e.Events.CopyFile(events)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	err = addToArchive(tw, archiveEvents, events)
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0)
+	for name := range archiveFiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		path := e.base + archiveFiles[name]
+		_, err = os.Stat(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		err = addToArchive(tw, name, path)
+		if err != nil {
+			return err
+		}
+	}
+	err = tw.Close()
+	if err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+func addToArchive(tw *tar.Writer, name string, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	err = tw.WriteHeader(&tar.Header{Name: name, Mode: 0600, Size: info.Size(), ModTime: time.Now(), Typeflag: tar.TypeReg})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+//Import creates a case from an archive written by Engine.Export and rebuilds its search index
+func (c *Cases) Import(ctx context.Context, r io.Reader, name string, progress ProgressFunc) error {
+	dir, err := c.Dir(name)
+	if err != nil {
+		return err
+	}
+	if c.Exists(name) {
+		return fmt.Errorf("Case '%v' already exists", name)
+	}
+	tmp := filepath.Join(filepath.Dir(dir), "."+filepath.Base(dir)+".import")
+	err = os.RemoveAll(tmp)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(tmp, 0700)
+	if err != nil {
+		return err
+	}
+	err = extractCase(r, tmp)
+	if err == nil {
+		err = os.Rename(tmp, dir)
+	}
+	if err != nil {
+		os.RemoveAll(tmp)
+		return err
+	}
+	e, err := c.Open(name, true)
+	if err != nil {
+		return err
+	}
+	defer e.Close()
+	return e.Reindex(ctx, progress)
+}
+
+//extractCase extracts the files of a case archive into dir, named as the engine files of a case
+func extractCase(r io.Reader, dir string) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+	base := filepath.Join(dir, strings.TrimSuffix(caseEventsFile, filepath.Ext(caseEventsFile)))
+	found := false
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		path := ""
+		if hdr.Name == archiveEvents {
+			path = filepath.Join(dir, caseEventsFile)
+			found = true
+		} else if suffix, ok := archiveFiles[hdr.Name]; ok {
+			path = base + suffix
+		}
+		if path == "" || hdr.Typeflag != tar.TypeReg {
+			return fmt.Errorf("Unexpected file '%v' in case archive", hdr.Name)
+		}
+		err = extractFile(tr, path)
+		if err != nil {
+			return err
+		}
+	}
+	if !found {
+		return fmt.Errorf("Case archive has no events")
+	}
+	return nil
+}
+
+func extractFile(r io.Reader, path string) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
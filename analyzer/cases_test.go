@@ -0,0 +1,77 @@
+package analyzer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/dtylman/korra/analyzer/cloudtrail"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCases(t *testing.T) {
+	dir, err := ioutil.TempDir("", "korra-cases")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	cases := NewCases(dir)
+
+	src, err := cases.Open(DefaultCase, true)
+	require.NoError(t, err)
+	defer src.Close()
+	start := time.Date(2018, 10, 30, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 20; i++ {
+		src.Events.AddEvent(cloudtrail.Event{ID: fmt.Sprintf("%02d", i), Name: "GetObject", SourceIPAddress: fmt.Sprintf("10.0.0.%v", i%4),
+			Time: start.Add(time.Duration(i) * time.Hour)})
+	}
+	require.NoError(t, src.Reindex(context.Background(), nil))
+
+	_, err = cases.Open("incident", false)
+	assert.Error(t, err)
+	assert.Error(t, cases.Create("../incident"))
+	copied, err := cases.CreateFrom(context.Background(), "incident", src,
+		SearchOptions{Filters: map[string]string{"sourceIPAddress": "10.0.0.1"}}, nil)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 5, copied)
+	_, err = cases.CreateFrom(context.Background(), "incident", src, SearchOptions{}, nil)
+	assert.Error(t, err)
+	assert.True(t, cases.Exists("incident"))
+
+	//a case that cannot be completed is removed
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = cases.CreateFrom(ctx, "cancelled", src, SearchOptions{Query: "eventName:AssumeRole"}, nil)
+	assert.Error(t, err)
+	assert.False(t, cases.Exists("cancelled"))
+
+	incident, err := cases.Open("incident", true)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 5, incident.Events.Len())
+	incident.Report(newHuntFinding(SavedSearch{Name: "ip"}, HuntReport{Time: time.Now(), EventIDs: []string{"01"}}))
+	var archive bytes.Buffer
+	assert.NoError(t, incident.Export(&archive))
+	assert.NoError(t, incident.Close())
+
+	assert.NoError(t, cases.Import(context.Background(), bytes.NewReader(archive.Bytes()), "handoff", nil))
+	assert.Error(t, cases.Import(context.Background(), bytes.NewReader(archive.Bytes()), "handoff", nil))
+	names, err := cases.List()
+	assert.NoError(t, err)
+	assert.EqualValues(t, []string{DefaultCase, "handoff", "incident"}, names)
+
+	handoff, err := cases.Open("handoff", true)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 5, handoff.Events.Len())
+	assert.EqualValues(t, 1, handoff.Findings.Len())
+	drift, err := handoff.IndexDrift()
+	assert.NoError(t, err)
+	assert.False(t, drift.Drifted())
+	assert.NoError(t, handoff.Close())
+
+	assert.NoError(t, cases.Delete("handoff"))
+	assert.Error(t, cases.Delete(DefaultCase))
+	assert.False(t, cases.Exists("handoff"))
+}
@@ -0,0 +1,195 @@
+package analyzer
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/dtylman/korra/analyzer/cloudtrail"
+)
+
+//Checkpoint is the newest event loaded from an account and region
+type Checkpoint struct {
+	Time    time.Time `json:"time"`
+	EventID string    `json:"eventId"`
+	//Resume is set if the last load of the account and region was interrupted
+	Resume *Resume `json:"resume,omitempty"`
+}
+
+//Resume is where an interrupted LookupEvents load continues from
+type Resume struct {
+	//NextToken is the token of the first page that was not fully read
+	NextToken string    `json:"nextToken"`
+	StartTime time.Time `json:"startTime"`
+	EndTime   time.Time `json:"endTime"`
+	//Newest is the newest event loaded before the interruption
+	Newest Checkpoint `json:"newest"`
+}
+
+//Checkpoints holds the checkpoint of every account and region, it is safe for concurrent use
+type Checkpoints struct {
+	mutex sync.RWMutex
+	items map[string]Checkpoint
+	path  string
+}
+
+//NewCheckpoints creates an empty checkpoint list persisted to the file at path
+func NewCheckpoints(path string) *Checkpoints {
+	return &Checkpoints{
+		items: make(map[string]Checkpoint),
+		path:  path,
+	}
+}
+
+func checkpointKey(account, region string) string {
+	return account + "/" + region
+}
+
+//Get returns the checkpoint of an account and region
+func (c *Checkpoints) Get(account, region string) (Checkpoint, bool) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	cp, ok := c.items[checkpointKey(account, region)]
+	return cp, ok
+}
+
+//Update moves the checkpoint of an account and region forward to cp, if cp is newer
+func (c *Checkpoints) Update(account, region string, cp Checkpoint) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	key := checkpointKey(account, region)
+	if cp.Time.After(c.items[key].Time) {
+		cp.Resume = c.items[key].Resume
+		c.items[key] = cp
+	}
+}
+
+//SetResume sets where the interrupted load of an account and region continues from, nil clears it
+func (c *Checkpoints) SetResume(account, region string, r *Resume) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	key := checkpointKey(account, region)
+	cp := c.items[key]
+	cp.Resume = r
+	c.items[key] = cp
+}
+
+//Clear removes all checkpoints
+func (c *Checkpoints) Clear() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.items = make(map[string]Checkpoint)
+}
+
+//Load loads checkpoints from file
+func (c *Checkpoints) Load() error {
+	_, err := os.Stat(c.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	data, err := ioutil.ReadFile(c.path)
+	if err != nil {
+		return err
+	}
+	items := make(map[string]Checkpoint)
+	err = json.Unmarshal(data, &items)
+	if err != nil {
+		return err
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.items = items
+	return nil
+}
+
+//Save persists all checkpoints to a local file
+func (c *Checkpoints) Save() error {
+	c.mutex.RLock()
+	data, err := json.Marshal(c.items)
+	c.mutex.RUnlock()
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(c.path, data, 0644)
+}
+
+//batch collects the events added to the engine by a single load
+type batch struct {
+	engine *Engine
+	mutex  sync.Mutex
+	events []cloudtrail.Event
+	newest map[string]cloudtrail.Event
+}
+
+func (e *Engine) newBatch() *batch {
+	return &batch{
+		engine: e,
+		events: make([]cloudtrail.Event, 0),
+		newest: make(map[string]cloudtrail.Event),
+	}
+}
+
+//add adds an event to the engine, returns false if the event was already loaded. Events already
+//loaded still move the checkpoint, e.g. when a load that failed is retried.
+func (b *batch) add(event cloudtrail.Event) bool {
+	added := b.engine.Events.AddNew(event)
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	key := checkpointKey(event.RecipientAccountID, event.Region)
+	if event.Time.After(b.newest[key].Time) {
+		b.newest[key] = event
+	}
+	if added {
+		b.events = append(b.events, event)
+	}
+	return added
+}
+
+//merge appends the events of a batch loaded concurrently, their checkpoints are committed by that batch
+func (b *batch) merge(other *batch) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.events = append(b.events, other.events...)
+}
+
+//partial returns true if a load with the options may skip events of an account and region newer
+//than its checkpoint, as it is filtered or starts after the checkpoint
+func (e *Engine) partial(opts *Options, account, region string) bool {
+	if opts.filtered() {
+		return true
+	}
+	cp, ok := e.Checkpoints.Get(account, region)
+	return ok && opts.StartTime.After(cp.Time)
+}
+
+//suspend records where an interrupted load of an account and region continues from,
+//unless the load was partial. prev is the resume state the load started from, if any.
+func (b *batch) suspend(opts *Options, account, region string, r Resume, prev *Resume) {
+	if b.engine.partial(opts, account, region) {
+		return
+	}
+	if prev != nil {
+		r.Newest = prev.Newest
+	}
+	for _, event := range b.newest {
+		if event.Time.After(r.Newest.Time) {
+			r.Newest = Checkpoint{Time: event.Time, EventID: event.ID}
+		}
+	}
+	b.engine.Checkpoints.SetResume(account, region, &r)
+}
+
+//commit moves the engine checkpoints forward to the newest events of the batch, except for the
+//accounts and regions the load was partial for and may have skipped events of
+func (b *batch) commit(opts *Options) {
+	for _, event := range b.newest {
+		if b.engine.partial(opts, event.RecipientAccountID, event.Region) {
+			log.Printf("%v %v: filtered load or start time after the checkpoint, checkpoint is not advanced", event.RecipientAccountID, event.Region)
+			continue
+		}
+		b.engine.Checkpoints.Update(event.RecipientAccountID, event.Region, Checkpoint{Time: event.Time, EventID: event.ID})
+	}
+}
@@ -0,0 +1,259 @@
+package cloudtrail
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+)
+
+//ErrNoDecoder is returned by Decode for events without a registered decoder
+var ErrNoDecoder = errors.New("No decoder for event")
+
+//Decoder decodes the parameters of an event into a typed value
+type Decoder func(e *Event) (interface{}, error)
+
+var (
+	decodersMutex sync.RWMutex
+	decoders      = make(map[string]Decoder)
+)
+
+func decoderKey(source, name string) string {
+	return source + "/" + name
+}
+
+//RegisterDecoder registers the decoder of the events with the given eventSource and eventName,
+//replacing a decoder registered before
+func RegisterDecoder(source, name string, d Decoder) {
+	decodersMutex.Lock()
+	defer decodersMutex.Unlock()
+	decoders[decoderKey(source, name)] = d
+}
+
+//HasDecoder returns true if a decoder is registered for the eventSource and eventName
+func HasDecoder(source, name string) bool {
+	decodersMutex.RLock()
+	defer decodersMutex.RUnlock()
+	_, ok := decoders[decoderKey(source, name)]
+	return ok
+}
+
+//Decode returns the typed parameters of the event (e.g. *AssumeRoleCall), ErrNoDecoder if there
+//is no decoder for its eventSource and eventName
+func (e *Event) Decode() (interface{}, error) {
+	decodersMutex.RLock()
+	d, ok := decoders[decoderKey(e.Source, e.Name)]
+	decodersMutex.RUnlock()
+	if !ok {
+		return nil, ErrNoDecoder
+	}
+	return d(e)
+}
+
+//JSONDecoder returns a decoder that decodes the requestParameters and responseElements of an event
+//into the Request and Response fields of the value returned by newCall
+func JSONDecoder(newCall func() interface{}) Decoder {
+	return func(e *Event) (interface{}, error) {
+		data, err := json.Marshal(map[string]Parameters{
+			"requestParameters": e.RequestParameters,
+			"responseElements":  e.ResponseElements,
+		})
+		if err != nil {
+			return nil, err
+		}
+		call := newCall()
+		err = json.Unmarshal(data, call)
+		if err != nil {
+			return nil, err
+		}
+		return call, nil
+	}
+}
+
+//registerCalls registers a JSONDecoder for every event name of a source
+func registerCalls(source string, newCall func() interface{}, names ...string) {
+	for _, name := range names {
+		RegisterDecoder(source, name, JSONDecoder(newCall))
+	}
+}
+
+//event sources with built in decoders
+const (
+	SourceSTS = "sts.amazonaws.com"
+	SourceIAM = "iam.amazonaws.com"
+	SourceS3  = "s3.amazonaws.com"
+	SourceEC2 = "ec2.amazonaws.com"
+	SourceKMS = "kms.amazonaws.com"
+)
+
+func init() {
+	registerCalls(SourceSTS, func() interface{} { return new(AssumeRoleCall) },
+		"AssumeRole", "AssumeRoleWithSAML", "AssumeRoleWithWebIdentity")
+	registerCalls(SourceSTS, func() interface{} { return new(SessionTokenCall) },
+		"GetSessionToken", "GetFederationToken")
+	registerCalls(SourceIAM, func() interface{} { return new(IAMPolicyCall) },
+		"PutUserPolicy", "PutRolePolicy", "PutGroupPolicy",
+		"DeleteUserPolicy", "DeleteRolePolicy", "DeleteGroupPolicy",
+		"AttachUserPolicy", "AttachRolePolicy", "AttachGroupPolicy",
+		"DetachUserPolicy", "DetachRolePolicy", "DetachGroupPolicy",
+		"CreatePolicy", "CreatePolicyVersion", "DeletePolicy", "UpdateAssumeRolePolicy")
+	registerCalls(SourceS3, func() interface{} { return new(BucketPolicyCall) },
+		"PutBucketPolicy", "DeleteBucketPolicy", "GetBucketPolicy")
+	registerCalls(SourceEC2, func() interface{} { return new(SecurityGroupCall) },
+		"CreateSecurityGroup", "DeleteSecurityGroup",
+		"AuthorizeSecurityGroupIngress", "AuthorizeSecurityGroupEgress",
+		"RevokeSecurityGroupIngress", "RevokeSecurityGroupEgress")
+	registerCalls(SourceKMS, func() interface{} { return new(KMSCall) },
+		"CreateKey", "CreateGrant", "RevokeGrant", "PutKeyPolicy", "DisableKey", "EnableKey",
+		"ScheduleKeyDeletion", "CancelKeyDeletion", "Decrypt", "Encrypt", "GenerateDataKey", "ReEncrypt")
+}
+
+//Credentials are temporary credentials issued by STS
+type Credentials struct {
+	AccessKeyID  string `json:"accessKeyId"`
+	Expiration   string `json:"expiration"`
+	SessionToken string `json:"sessionToken"`
+}
+
+//AssumedRoleUser is the session identity returned by the AssumeRole calls
+type AssumedRoleUser struct {
+	AssumedRoleID string `json:"assumedRoleId"`
+	ARN           string `json:"arn"`
+}
+
+//AssumeRoleCall is an AssumeRole, AssumeRoleWithSAML or AssumeRoleWithWebIdentity call
+type AssumeRoleCall struct {
+	Request struct {
+		RoleArn         string `json:"roleArn"`
+		RoleSessionName string `json:"roleSessionName"`
+		ExternalID      string `json:"externalId"`
+		SerialNumber    string `json:"serialNumber"`
+		DurationSeconds int    `json:"durationSeconds"`
+		Policy          string `json:"policy"`
+		//PrincipalArn is the SAML provider of AssumeRoleWithSAML
+		PrincipalArn string `json:"principalArn"`
+		//ProviderID is the web identity provider of AssumeRoleWithWebIdentity
+		ProviderID string `json:"providerId"`
+	} `json:"requestParameters"`
+	Response struct {
+		Credentials     Credentials     `json:"credentials"`
+		AssumedRoleUser AssumedRoleUser `json:"assumedRoleUser"`
+		//SubjectFromWebIdentityToken is set by AssumeRoleWithWebIdentity
+		SubjectFromWebIdentityToken string `json:"subjectFromWebIdentityToken"`
+		//Subject is set by AssumeRoleWithSAML
+		Subject string `json:"subject"`
+	} `json:"responseElements"`
+}
+
+//SessionTokenCall is a GetSessionToken or GetFederationToken call
+type SessionTokenCall struct {
+	Request struct {
+		//Name is the federated user name of GetFederationToken
+		Name            string `json:"name"`
+		Policy          string `json:"policy"`
+		SerialNumber    string `json:"serialNumber"`
+		DurationSeconds int    `json:"durationSeconds"`
+	} `json:"requestParameters"`
+	Response struct {
+		Credentials   Credentials `json:"credentials"`
+		FederatedUser struct {
+			FederatedUserID string `json:"federatedUserId"`
+			ARN             string `json:"arn"`
+		} `json:"federatedUser"`
+	} `json:"responseElements"`
+}
+
+//IAMPolicyCall is a call that changes an inline, managed or trust policy of an IAM identity
+type IAMPolicyCall struct {
+	Request struct {
+		UserName       string `json:"userName"`
+		RoleName       string `json:"roleName"`
+		GroupName      string `json:"groupName"`
+		PolicyName     string `json:"policyName"`
+		PolicyArn      string `json:"policyArn"`
+		PolicyDocument string `json:"policyDocument"`
+		SetAsDefault   bool   `json:"setAsDefault"`
+	} `json:"requestParameters"`
+	Response struct {
+		Policy struct {
+			PolicyName string `json:"policyName"`
+			ARN        string `json:"arn"`
+		} `json:"policy"`
+	} `json:"responseElements"`
+}
+
+//BucketPolicyCall is a call on the policy of an S3 bucket
+type BucketPolicyCall struct {
+	Request struct {
+		BucketName string `json:"bucketName"`
+		//BucketPolicy is the policy document of PutBucketPolicy
+		BucketPolicy map[string]interface{} `json:"bucketPolicy"`
+	} `json:"requestParameters"`
+}
+
+//IPPermission is a security group rule
+type IPPermission struct {
+	IPProtocol string `json:"ipProtocol"`
+	FromPort   int    `json:"fromPort"`
+	ToPort     int    `json:"toPort"`
+	IPRanges   struct {
+		Items []struct {
+			CidrIP string `json:"cidrIp"`
+		} `json:"items"`
+	} `json:"ipRanges"`
+	IPv6Ranges struct {
+		Items []struct {
+			CidrIPv6 string `json:"cidrIpv6"`
+		} `json:"items"`
+	} `json:"ipv6Ranges"`
+	Groups struct {
+		Items []struct {
+			GroupID string `json:"groupId"`
+		} `json:"items"`
+	} `json:"groups"`
+}
+
+//CIDRs returns the IPv4 and IPv6 ranges of the rule
+func (p *IPPermission) CIDRs() []string {
+	cidrs := make([]string, 0)
+	for _, r := range p.IPRanges.Items {
+		cidrs = append(cidrs, r.CidrIP)
+	}
+	for _, r := range p.IPv6Ranges.Items {
+		cidrs = append(cidrs, r.CidrIPv6)
+	}
+	return cidrs
+}
+
+//SecurityGroupCall is a call that creates, deletes or changes the rules of an EC2 security group
+type SecurityGroupCall struct {
+	Request struct {
+		GroupID          string `json:"groupId"`
+		GroupName        string `json:"groupName"`
+		GroupDescription string `json:"groupDescription"`
+		VpcID            string `json:"vpcId"`
+		IPPermissions    struct {
+			Items []IPPermission `json:"items"`
+		} `json:"ipPermissions"`
+	} `json:"requestParameters"`
+	Response struct {
+		GroupID string `json:"groupId"`
+	} `json:"responseElements"`
+}
+
+//KMSCall is a call on a KMS key, its policy or grants
+type KMSCall struct {
+	Request struct {
+		KeyID               string                 `json:"keyId"`
+		PolicyName          string                 `json:"policyName"`
+		Policy              string                 `json:"policy"`
+		GranteePrincipal    string                 `json:"granteePrincipal"`
+		Operations          []string               `json:"operations"`
+		PendingWindowInDays int                    `json:"pendingWindowInDays"`
+		EncryptionContext   map[string]interface{} `json:"encryptionContext"`
+	} `json:"requestParameters"`
+	Response struct {
+		KeyID    string `json:"keyId"`
+		GrantID  string `json:"grantId"`
+		KeyState string `json:"keyState"`
+	} `json:"responseElements"`
+}
@@ -0,0 +1,42 @@
+package cloudtrail
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvent_Decode(t *testing.T) {
+	e, err := NewEvent([]byte(`{"eventSource":"ec2.amazonaws.com","eventName":"AuthorizeSecurityGroupIngress",
+		"requestParameters":{"groupId":"sg-1","ipPermissions":{"items":[{"ipProtocol":"tcp","fromPort":22,"toPort":22,
+		"ipRanges":{"items":[{"cidrIp":"0.0.0.0/0"}]},"ipv6Ranges":{"items":[{"cidrIpv6":"::/0"}]}}]}},
+		"responseElements":{"_return":true}}`))
+	assert.NoError(t, err)
+	assert.EqualValues(t, "sg-1", e.RequestParameters.String("groupId"))
+	v, err := e.Decode()
+	assert.NoError(t, err)
+	call, ok := v.(*SecurityGroupCall)
+	assert.True(t, ok)
+	assert.EqualValues(t, "sg-1", call.Request.GroupID)
+	assert.Len(t, call.Request.IPPermissions.Items, 1)
+	assert.EqualValues(t, 22, call.Request.IPPermissions.Items[0].FromPort)
+	assert.EqualValues(t, []string{"0.0.0.0/0", "::/0"}, call.Request.IPPermissions.Items[0].CIDRs())
+
+	e, err = NewEvent([]byte(`{"eventSource":"sts.amazonaws.com","eventName":"AssumeRole",
+		"requestParameters":{"roleArn":"arn:aws:iam::1:role/admin","roleSessionName":"x","durationSeconds":3600},
+		"responseElements":{"credentials":{"accessKeyId":"ASIA1","expiration":"Oct 29, 2018 12:03:44 PM"},
+		"assumedRoleUser":{"assumedRoleId":"AROA1:x","arn":"arn:aws:sts::1:assumed-role/admin/x"}}}`))
+	assert.NoError(t, err)
+	v, err = e.Decode()
+	assert.NoError(t, err)
+	assume := v.(*AssumeRoleCall)
+	assert.EqualValues(t, 3600, assume.Request.DurationSeconds)
+	assert.EqualValues(t, "ASIA1", assume.Response.Credentials.AccessKeyID)
+	assert.EqualValues(t, assume.Response.AssumedRoleUser.ARN, e.ResponseElements.String("assumedRoleUser", "arn"))
+	assert.Empty(t, e.ResponseElements.String("assumedRoleUser", "arn", "missing"))
+
+	_, err = (&Event{Source: "sqs.amazonaws.com", Name: "SendMessage"}).Decode()
+	assert.Equal(t, ErrNoDecoder, err)
+	assert.True(t, HasDecoder(SourceKMS, "ScheduleKeyDeletion"))
+	assert.False(t, HasDecoder(SourceKMS, "ListKeys"))
+}
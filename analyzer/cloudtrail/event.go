@@ -1,7 +1,9 @@
 package cloudtrail
 
 import (
+	"bytes"
 	"encoding/json"
+	"io"
 	"strings"
 	"time"
 )
@@ -11,36 +13,146 @@ type Log struct {
 	Records []Event `json:"Records"`
 }
 
-//UserIdentity ...
+//rawLog is a Log whose records are kept undecoded
+type rawLog struct {
+	Records []json.RawMessage `json:"Records"`
+}
+
+//NewEvent decodes a single CloudTrail record and keeps its raw JSON
+func NewEvent(raw []byte) (Event, error) {
+	var e Event
+	err := json.Unmarshal(raw, &e)
+	if err != nil {
+		return e, err
+	}
+	e.RawEvent = string(raw)
+	return e, nil
+}
+
+//ReadLog decodes a CloudTrail log file ({"Records":[...]}) from r
+func ReadLog(r io.Reader) ([]Event, error) {
+	var l rawLog
+	err := json.NewDecoder(r).Decode(&l)
+	if err != nil {
+		return nil, err
+	}
+	events := make([]Event, 0, len(l.Records))
+	for _, raw := range l.Records {
+		e, err := NewEvent(raw)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, nil
+}
+
+//UserIdentity is the identity that made the request
 type UserIdentity struct {
-	Type     string `json:"type"`
-	ARN      string `json:"arn"`
-	UserName string `json:"userName"`
+	Type        string `json:"type"`
+	PrincipalID string `json:"principalId,omitempty"`
+	ARN         string `json:"arn"`
+	AccountID   string `json:"accountId,omitempty"`
+	UserName    string `json:"userName"`
+	AccessKeyID string `json:"accessKeyId"`
+	//InvokedBy is the AWS service that made the request on behalf of the identity
+	InvokedBy string `json:"invokedBy,omitempty"`
+	//IdentityProvider is the principal name of the external identity provider (SAML and web identity)
+	IdentityProvider string `json:"identityProvider,omitempty"`
+	//SessionContext is set for requests made with temporary credentials
+	SessionContext *SessionContext `json:"sessionContext,omitempty"`
+}
+
+//SessionContext describes the session of temporary credentials
+type SessionContext struct {
+	Attributes          SessionAttributes    `json:"attributes"`
+	SessionIssuer       *SessionIssuer       `json:"sessionIssuer,omitempty"`
+	WebIDFederationData *WebIDFederationData `json:"webIdFederationData,omitempty"`
+	SourceIdentity      string               `json:"sourceIdentity,omitempty"`
+}
+
+//SessionAttributes tell when the session credentials were issued and whether MFA was used
+type SessionAttributes struct {
+	//MFAAuthenticated is "true" or "false"
+	MFAAuthenticated string    `json:"mfaAuthenticated"`
+	CreationDate     time.Time `json:"creationDate"`
 }
 
-//RequestParameters ...
-type RequestParameters struct {
-	RoleArn         string `json:"roleArn"`
-	RoleSessionName string `json:"roleSessionName"`
+//SessionIssuer is the identity the session credentials were obtained from, e.g. the assumed role
+type SessionIssuer struct {
+	Type        string `json:"type"`
+	PrincipalID string `json:"principalId"`
+	ARN         string `json:"arn"`
+	AccountID   string `json:"accountId"`
+	UserName    string `json:"userName"`
 }
 
-//Credentials ...
-type Credentials struct {
-	AccessKeyID  string `json:"accessKeyId"`
-	Expiration   string `json:"expiration"`
-	SessionToken string `json:"sessionToken"`
+//WebIDFederationData holds the identity provider of credentials obtained by web identity federation
+type WebIDFederationData struct {
+	FederatedProvider string                 `json:"federatedProvider"`
+	Attributes        map[string]interface{} `json:"attributes,omitempty"`
 }
 
-//AssumedRoleUser ...
-type AssumedRoleUser struct {
-	AssumedRoleID string `json:"assumedRoleId"`
-	ARN           string `json:"arn"`
+//MFA returns true if the request was made with credentials of an MFA authenticated session
+func (ui *UserIdentity) MFA() bool {
+	return ui.SessionContext != nil && ui.SessionContext.Attributes.MFAAuthenticated == "true"
 }
 
-//ResponseElements ...
-type ResponseElements struct {
-	Credentials     Credentials     `json:"credentials"`
-	AssumedRoleUser AssumedRoleUser `json:"assumedRoleUser"`
+//Issuer returns the ARN of the identity the session credentials were issued by, if any
+func (ui *UserIdentity) Issuer() string {
+	if ui.SessionContext == nil || ui.SessionContext.SessionIssuer == nil {
+		return ""
+	}
+	return ui.SessionContext.SessionIssuer.ARN
+}
+
+//decodeRaw decodes the event again from its raw record, events stored by older versions kept
+//only some of their fields
+func (e *Event) decodeRaw() error {
+	if e.RawEvent == "" {
+		return nil
+	}
+	record, err := NewEvent([]byte(e.RawEvent))
+	if err != nil {
+		return err
+	}
+	*e = record
+	return nil
+}
+
+//ServiceInvoked returns true if an AWS service made the request on behalf of the identity
+func (ui *UserIdentity) ServiceInvoked() bool {
+	return ui.InvokedBy != "" || ui.Type == "AWSService"
+}
+
+//Parameters holds the requestParameters or responseElements of an event as decoded from JSON,
+//see Event.Decode for typed parameters
+type Parameters map[string]interface{}
+
+//Get returns the value at a path of nested objects
+func (p Parameters) Get(path ...string) (interface{}, bool) {
+	var value interface{} = map[string]interface{}(p)
+	for _, name := range path {
+		switch m := value.(type) {
+		case map[string]interface{}:
+			value = m[name]
+		case Parameters:
+			value = m[name]
+		default:
+			return nil, false
+		}
+		if value == nil {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+//String returns the string at a path of nested objects, empty if there is none
+func (p Parameters) String(path ...string) string {
+	value, _ := p.Get(path...)
+	s, _ := value.(string)
+	return s
 }
 
 // Resource ...
@@ -52,22 +164,46 @@ type Resource struct {
 
 //Event is AWS cloud trail event
 type Event struct {
-	Source             string            `json:"eventSource"`
-	ErrorCode          string            `json:"errorCode"`
-	Name               string            `json:"eventName"`
-	UserIdentity       UserIdentity      `json:"userIdentity"`
-	SourceIPAddress    string            `json:"sourceIPAddress"`
-	UserAgent          string            `json:"userAgent"`
-	Time               time.Time         `json:"eventTime"`
-	Region             string            `json:"awsRegion"`
-	RequestParameters  RequestParameters `json:"requestParameters"`
-	ResponseElements   ResponseElements  `json:"responseElements"`
-	RequestID          string            `json:"requestID"`
-	ID                 string            `json:"eventID"`
-	Resources          []Resource        `json:"resources"`
-	Type               string            `json:"eventType"`
-	RecipientAccountID string            `json:"recipientAccountId"`
-	RawEvent           string            `json:"raw"`
+	Source             string       `json:"eventSource"`
+	ErrorCode          string       `json:"errorCode"`
+	Name               string       `json:"eventName"`
+	UserIdentity       UserIdentity `json:"userIdentity"`
+	SourceIPAddress    string       `json:"sourceIPAddress"`
+	UserAgent          string       `json:"userAgent"`
+	Time               time.Time    `json:"eventTime"`
+	Region             string       `json:"awsRegion"`
+	RequestParameters  Parameters   `json:"requestParameters"`
+	ResponseElements   Parameters   `json:"responseElements"`
+	RequestID          string       `json:"requestID"`
+	ID                 string       `json:"eventID"`
+	Resources          []Resource   `json:"resources"`
+	Type               string       `json:"eventType"`
+	RecipientAccountID string       `json:"recipientAccountId"`
+	ReadOnly           bool         `json:"readOnly"`
+	EventVersion       string       `json:"eventVersion,omitempty"`
+	//ManagementEvent is nil for records older than eventVersion 1.06
+	ManagementEvent *bool `json:"managementEvent,omitempty"`
+	//EventCategory is Management, Data or Insight
+	EventCategory       string     `json:"eventCategory,omitempty"`
+	AdditionalEventData Parameters `json:"additionalEventData,omitempty"`
+	//ServiceEventDetails is set on events made by AWS services rather than API calls
+	ServiceEventDetails Parameters `json:"serviceEventDetails,omitempty"`
+	//SharedEventID is the same on the records of an event delivered to several accounts
+	SharedEventID string `json:"sharedEventID,omitempty"`
+	//VpcEndpointID is the VPC endpoint the request was made through
+	VpcEndpointID string      `json:"vpcEndpointId,omitempty"`
+	TLSDetails    *TLSDetails `json:"tlsDetails,omitempty"`
+	//SessionCredentialFromConsole is "true" for requests made with credentials of a console session
+	SessionCredentialFromConsole string `json:"sessionCredentialFromConsole,omitempty"`
+	APIVersion                   string `json:"apiVersion,omitempty"`
+	RawEvent                     string `json:"raw"`
+}
+
+//TLSDetails is the TLS connection of a request
+type TLSDetails struct {
+	TLSVersion               string `json:"tlsVersion"`
+	CipherSuite              string `json:"cipherSuite"`
+	ClientProvidedHostHeader string `json:"clientProvidedHostHeader"`
 }
 
 // ByTime sorts events by time
@@ -82,14 +218,15 @@ func (e *Event) BuildAssumedRoleARN() string {
 	if e.Name != "AssumeRole" {
 		return ""
 	}
-	arn := e.ResponseElements.AssumedRoleUser.ARN
+	arn := e.ResponseElements.String("assumedRoleUser", "arn")
 	if arn == "" {
-		if e.RequestParameters.RoleArn == "" {
+		roleArn := e.RequestParameters.String("roleArn")
+		if roleArn == "" {
 			return ""
 		}
-		colonparts := strings.Split(e.RequestParameters.RoleArn, ":")
+		colonparts := strings.Split(roleArn, ":")
 		slashparts := strings.Split(colonparts[len(colonparts)-1], "/")
-		slashparts = append(slashparts, e.RequestParameters.RoleSessionName)
+		slashparts = append(slashparts, e.RequestParameters.String("roleSessionName"))
 		colonparts[len(colonparts)-1] = "assumed-role"
 		colonparts[2] = "sts"
 		arn = strings.Join(colonparts, ":") + "/" + strings.Join(slashparts[1:], "/")
@@ -106,6 +243,19 @@ func (e *Event) JSONString(prefix, indent string) (string, error) {
 	return string(data), nil
 }
 
+//RawJSONString exports the original CloudTrail record as an indented JSON string
+func (e *Event) RawJSONString(prefix, indent string) (string, error) {
+	if e.RawEvent == "" {
+		return e.JSONString(prefix, indent)
+	}
+	var buf bytes.Buffer
+	err := json.Indent(&buf, []byte(e.RawEvent), prefix, indent)
+	if err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
 //HasError checks if errorcode is empty
 func (e *Event) HasError() bool {
 	return e.ErrorCode != ""
@@ -0,0 +1,198 @@
+package cloudtrail
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+//countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+//importer adds events from archives to a store, skipping known event IDs
+type importer struct {
+	ctx      context.Context
+	store    *Store
+	added    int
+	done     int64
+	total    int64
+	progress func(value int, total int)
+	//failed are the files, or archive entries, that could not be read
+	failed []string
+}
+
+//Import reads CloudTrail files from path (a file, a tarball or a directory which is read
+//recursively) and adds events not already loaded. Plain, gzipped and tarred files are
+//supported, holding either a {"Records":[...]} log or JSON lines. Returns the number of events added,
+//events added before ctx is cancelled are kept. Files that cannot be read are skipped and reported by
+//the error once the others are imported, records that are not events are skipped.
+func (s *Store) Import(ctx context.Context, path string, progress func(value int, total int)) (int, error) {
+	imp := &importer{
+		ctx:      ctx,
+		store:    s,
+		progress: progress,
+	}
+	files := make([]string, 0)
+	err := filepath.Walk(path, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && isImportable(name) {
+			files = append(files, name)
+			imp.total += info.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	for _, name := range files {
+		if ctx.Err() != nil {
+			return imp.added, ctx.Err()
+		}
+		err = imp.importFile(name)
+		if err != nil {
+			imp.fail(name, err)
+		}
+	}
+	if ctx.Err() != nil {
+		return imp.added, ctx.Err()
+	}
+	imp.report(0)
+	if len(imp.failed) > 0 {
+		return imp.added, fmt.Errorf("Cannot import %v files: %v", len(imp.failed), strings.Join(imp.failed, ", "))
+	}
+	return imp.added, nil
+}
+
+func isImportable(name string) bool {
+	name = strings.TrimSuffix(strings.ToLower(name), ".gz")
+	return strings.HasSuffix(name, ".json") || strings.HasSuffix(name, ".jsonl") ||
+		strings.HasSuffix(name, ".tar") || strings.HasSuffix(name, ".tgz")
+}
+
+func isTar(name string) bool {
+	name = strings.ToLower(name)
+	return strings.HasSuffix(name, ".tar") || strings.HasSuffix(name, ".tar.gz") || strings.HasSuffix(name, ".tgz")
+}
+
+//fail logs a file that cannot be read and adds it to the failed files, unless the import is cancelled
+func (imp *importer) fail(name string, err error) {
+	if imp.ctx.Err() != nil {
+		return
+	}
+	log.Printf("%v: %v", name, err)
+	imp.failed = append(imp.failed, name)
+}
+
+func (imp *importer) report(read int64) {
+	if imp.progress != nil {
+		imp.progress(int(imp.done+read), int(imp.total))
+	}
+}
+
+func (imp *importer) importFile(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	cr := &countingReader{r: f}
+	defer func() {
+		imp.done += cr.n
+		imp.report(0)
+	}()
+	r, err := uncompress(name, cr)
+	if err != nil {
+		return err
+	}
+	if !isTar(name) {
+		return imp.importReader(name, r)
+	}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg || isTar(hdr.Name) || !isImportable(hdr.Name) {
+			continue
+		}
+		entry, err := uncompress(hdr.Name, tr)
+		if err == nil {
+			err = imp.importReader(name+"/"+hdr.Name, entry)
+		}
+		if err != nil {
+			imp.fail(name+"/"+hdr.Name, err)
+		}
+		imp.report(cr.n)
+	}
+}
+
+//uncompress returns a reader over the plain content of a file named name
+func uncompress(name string, r io.Reader) (io.Reader, error) {
+	name = strings.ToLower(name)
+	if strings.HasSuffix(name, ".gz") || strings.HasSuffix(name, ".tgz") {
+		return gzip.NewReader(r)
+	}
+	return r, nil
+}
+
+//importReader reads a stream of JSON values of the file name, each is either a Log or a single record
+func (imp *importer) importReader(name string, r io.Reader) error {
+	dec := json.NewDecoder(r)
+	for {
+		if imp.ctx.Err() != nil {
+			return imp.ctx.Err()
+		}
+		var raw json.RawMessage
+		err := dec.Decode(&raw)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		records := []json.RawMessage{raw}
+		if bytes.Contains(raw, []byte(`"Records"`)) {
+			var l rawLog
+			err = json.Unmarshal(raw, &l)
+			if err != nil {
+				return err
+			}
+			if l.Records != nil {
+				records = l.Records
+			}
+		}
+		for _, record := range records {
+			e, err := NewEvent(record)
+			if err != nil {
+				log.Printf("%v: skipping record: %v", name, err)
+				continue
+			}
+			if e.ID != "" && imp.store.AddNew(e) {
+				imp.added++
+			}
+		}
+	}
+}
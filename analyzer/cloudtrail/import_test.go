@@ -0,0 +1,112 @@
+package cloudtrail
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func gzipBytes(t *testing.T, data []byte) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	_, err := gz.Write(data)
+	assert.NoError(t, err)
+	assert.NoError(t, gz.Close())
+	return buf.Bytes()
+}
+
+func tarBytes(t *testing.T, files map[string][]byte) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for name, data := range files {
+		assert.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), Typeflag: tar.TypeReg}))
+		_, err := tw.Write(data)
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, tw.Close())
+	return buf.Bytes()
+}
+
+func TestImport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "korra-import")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	files := map[string][]byte{
+		"a/1.json":      []byte(`{"Records":[{"eventID":"1","eventName":"AssumeRole"},{"eventID":"2"}]}`),
+		"a/b/2.json.gz": gzipBytes(t, []byte(`{"Records":[{"eventID":"2"},{"eventID":"3"}]}`)),
+		"lines.jsonl":   []byte("{\"eventID\":\"4\"}\n{\"eventID\":\"5\"}\n"),
+		"export.tar.gz": gzipBytes(t, tarBytes(t, map[string][]byte{
+			"x/6.json.gz":  gzipBytes(t, []byte(`{"Records":[{"eventID":"6"}]}`)),
+			"x/readme.txt": []byte("not json"),
+		})),
+		"notes.txt": []byte("ignored"),
+	}
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		assert.NoError(t, ioutil.WriteFile(path, data, 0644))
+	}
+
+	store := NewStore(filepath.Join(dir, "events.db"))
+	assert.NoError(t, store.Open())
+	var value, total int
+	added, err := store.Import(context.Background(), dir, func(v int, t int) { value, total = v, t })
+	assert.NoError(t, err)
+	assert.EqualValues(t, 6, added)
+	assert.EqualValues(t, 6, store.Len())
+	assert.EqualValues(t, total, value)
+	assert.Contains(t, store.Events()[0].RawEvent, `"eventID"`)
+
+	added, err = store.Import(context.Background(), dir, nil)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 0, added)
+	assert.EqualValues(t, 6, store.Len())
+
+	events := store.Events()
+	assert.NoError(t, store.Close())
+	loaded := NewStore(filepath.Join(dir, "events.db"))
+	assert.NoError(t, loaded.Open())
+	defer loaded.Close()
+	assert.EqualValues(t, events, loaded.Events())
+	e, ok := loaded.Get("5")
+	assert.True(t, ok)
+	assert.EqualValues(t, "5", e.ID)
+}
+
+func TestImport_Failures(t *testing.T) {
+	dir, err := ioutil.TempDir("", "korra-import")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	files := map[string][]byte{
+		"1.json":      []byte(`{"Records":[{"eventID":"1"},{"eventID":7},{"eventID":"2"}]}`),
+		"broken.json": []byte(`{"Records":[{"eventID":"3"}`),
+		"bad.json.gz": []byte("not gzipped"),
+		"lines.jsonl": []byte("{\"eventID\":\"4\"}\n[1]\n{\"eventID\":\"5\"}\n"),
+	}
+	for name, data := range files {
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), data, 0644))
+	}
+
+	store := NewStore(filepath.Join(dir, "events.db"))
+	assert.NoError(t, store.Open())
+	defer store.Close()
+	added, err := store.Import(context.Background(), dir, nil)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "2 files")
+	assert.Contains(t, err.Error(), "broken.json")
+	assert.Contains(t, err.Error(), "bad.json.gz")
+	assert.EqualValues(t, 4, added)
+	for _, id := range []string{"1", "2", "4", "5"} {
+		_, ok := store.Get(id)
+		assert.True(t, ok, id)
+	}
+}
@@ -1,61 +1,531 @@
 package cloudtrail
 
 import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
+	"log"
 	"os"
-	"sort"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	//eventsBucket maps event IDs to compressed events
+	eventsBucket = []byte("events")
+	//timeBucket maps the time and ID of every event to its ID, events are iterated in its order
+	timeBucket = []byte("time")
+)
+
+const (
+	//flushSize is the number of added events kept in memory before they are written
+	flushSize = 1000
+	//eventsPerRead is the number of events read at once by Each
+	eventsPerRead = 1000
+)
+
+//kinds of stored events
+const (
+	//kindRaw is stored as its raw CloudTrail record with the account and region of the event,
+	//which loaders set if the record does not carry them
+	kindRaw byte = 'r'
+	//kindEvent is stored as the JSON of the Event, for events without a raw record
+	kindEvent byte = 'e'
 )
 
-//Events holds the list of loaded events
-var Events []Event
+//flateWriters are reused as every writer allocates large buffers
+var flateWriters = sync.Pool{
+	New: func() interface{} {
+		w, _ := flate.NewWriter(nil, flate.BestSpeed)
+		return w
+	},
+}
+
+//Store holds the loaded events in an embedded database keyed by event ID with a time index,
+//it is safe for concurrent use. Open must be called before the store is used.
+type Store struct {
+	mutex      sync.RWMutex
+	db         *bolt.DB
+	path       string
+	count      int
+	pending    []Event
+	pendingIDs map[string]int
+	err        error
+}
+
+//NewStore creates a store persisted to the database file at path
+func NewStore(path string) *Store {
+	return &Store{
+		path:       path,
+		pending:    make([]Event, 0),
+		pendingIDs: make(map[string]int),
+	}
+}
+
+//Open opens the database, creating it if it does not exist
+func (s *Store) Open() error {
+	db, err := bolt.Open(s.path, 0644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return fmt.Errorf("Cannot open the event store %v: %v", s.path, err)
+	}
+	count := 0
+	err = db.Update(func(tx *bolt.Tx) error {
+		events, err := tx.CreateBucketIfNotExists(eventsBucket)
+		if err != nil {
+			return err
+		}
+		_, err = tx.CreateBucketIfNotExists(timeBucket)
+		count = events.Stats().KeyN
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.db = db
+	s.count = count
+	return nil
+}
+
+//Migrate adds the events of a JSON file written by older versions and renames the file to
+//path.migrated, it does nothing if the file does not exist. The events are decoded again from
+//their raw records as older versions stored only some of their fields.
+func (s *Store) Migrate(path string) (int, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	log.Printf("Migrating events from %v to %v...", path, s.path)
+	dec := json.NewDecoder(f)
+	_, err = dec.Token()
+	if err != nil {
+		return 0, err
+	}
+	added := 0
+	for dec.More() {
+		var e Event
+		err = dec.Decode(&e)
+		if err != nil {
+			return added, err
+		}
+		err = e.decodeRaw()
+		if err != nil {
+			return added, err
+		}
+		if s.AddNew(e) {
+			added++
+		}
+	}
+	err = s.Save()
+	if err != nil {
+		return added, err
+	}
+	f.Close()
+	log.Printf("Migrated %v events", added)
+	return added, os.Rename(path, path+".migrated")
+}
+
+//Clear removes all events
+func (s *Store) Clear() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.pending = make([]Event, 0)
+	s.pendingIDs = make(map[string]int)
+	s.count = 0
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{eventsBucket, timeBucket} {
+			err := tx.DeleteBucket(name)
+			if err != nil && err != bolt.ErrBucketNotFound {
+				return err
+			}
+			_, err = tx.CreateBucket(name)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Println(err)
+		s.setErr(err)
+	}
+}
+
+//AddEvent adds one event, replacing an event with the same ID
+func (s *Store) AddEvent(event Event) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.add(event, true)
+}
+
+//AddNew adds an event unless an event with the same ID was already added, returns true if it was added
+func (s *Store) AddNew(event Event) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.add(event, false)
+}
+
+//add queues an event to be written, callers must hold the write lock
+func (s *Store) add(event Event, replace bool) bool {
+	if i, ok := s.pendingIDs[event.ID]; ok {
+		if replace {
+			s.pending[i] = event
+		}
+		return replace
+	}
+	stored := s.exists(event.ID)
+	if stored && !replace {
+		return false
+	}
+	if !stored {
+		s.count++
+	}
+	s.pendingIDs[event.ID] = len(s.pending)
+	s.pending = append(s.pending, event)
+	if len(s.pending) >= flushSize {
+		s.setErr(s.flush())
+	}
+	return true
+}
+
+//setErr keeps the first error of a write that was not returned to the caller, see Save
+func (s *Store) setErr(err error) {
+	if s.err == nil {
+		s.err = err
+	}
+}
+
+//flush writes the queued events, callers must hold the write lock
+func (s *Store) flush() error {
+	if len(s.pending) == 0 {
+		return nil
+	}
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		events := tx.Bucket(eventsBucket)
+		times := tx.Bucket(timeBucket)
+		for _, e := range s.pending {
+			id := []byte(e.ID)
+			old := events.Get(id)
+			if old != nil {
+				prev, err := decodeEvent(old)
+				if err != nil {
+					return err
+				}
+				err = times.Delete(timeKey(prev))
+				if err != nil {
+					return err
+				}
+			}
+			value, err := encodeEvent(e)
+			if err != nil {
+				return err
+			}
+			err = events.Put(id, value)
+			if err != nil {
+				return err
+			}
+			err = times.Put(timeKey(e), id)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	s.pending = make([]Event, 0)
+	s.pendingIDs = make(map[string]int)
+	return err
+}
+
+//Get returns the event with the given ID
+func (s *Store) Get(id string) (Event, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if i, ok := s.pendingIDs[id]; ok {
+		return s.pending[i], true
+	}
+	return s.get(id)
+}
+
+//get reads an event from the database, callers must hold the lock
+func (s *Store) get(id string) (Event, bool) {
+	var e Event
+	found := false
+	err := s.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(eventsBucket).Get([]byte(id))
+		if value == nil {
+			return nil
+		}
+		var err error
+		e, err = decodeEvent(value)
+		found = err == nil
+		return err
+	})
+	if err != nil {
+		log.Printf("Event '%v': %v", id, err)
+	}
+	return e, found
+}
 
-//Clear resets the list of loaded events
-func Clear() {
-	Events = make([]Event, 0)
+//exists returns true if an event with the given ID was written, callers must hold the lock
+func (s *Store) exists(id string) bool {
+	found := false
+	err := s.db.View(func(tx *bolt.Tx) error {
+		found = tx.Bucket(eventsBucket).Get([]byte(id)) != nil
+		return nil
+	})
+	if err != nil {
+		log.Printf("Event '%v': %v", id, err)
+	}
+	return found
+}
+
+//Len returns the number of events
+func (s *Store) Len() int {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.count
+}
+
+//Each calls fn for every event in time order, the events are read a chunk at a time.
+//It stops at the first error returned by fn and returns it.
+func (s *Store) Each(fn func(e Event) error) error {
+	err := s.Save()
+	if err != nil {
+		return err
+	}
+	s.mutex.RLock()
+	db := s.db
+	s.mutex.RUnlock()
+	var after []byte
+	for {
+		chunk := make([]Event, 0, eventsPerRead)
+		err = db.View(func(tx *bolt.Tx) error {
+			events := tx.Bucket(eventsBucket)
+			c := tx.Bucket(timeBucket).Cursor()
+			k, id := c.First()
+			if after != nil {
+				k, id = c.Seek(after)
+				if bytes.Equal(k, after) {
+					k, id = c.Next()
+				}
+			}
+			for ; k != nil && len(chunk) < eventsPerRead; k, id = c.Next() {
+				e, err := decodeEvent(events.Get(id))
+				if err != nil {
+					return err
+				}
+				chunk = append(chunk, e)
+				after = append(after[:0], k...)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, e := range chunk {
+			err = fn(e)
+			if err != nil {
+				return err
+			}
+		}
+		if len(chunk) < eventsPerRead {
+			return nil
+		}
+	}
 }
 
-//AddEvent adds one event
-func AddEvent(event Event) {
-	Events = append(Events, event)
+//Events returns all events in time order, use Each to avoid reading them all into memory
+func (s *Store) Events() []Event {
+	list := make([]Event, 0)
+	err := s.Each(func(e Event) error {
+		list = append(list, e)
+		return nil
+	})
+	if err != nil {
+		log.Println(err)
+	}
+	return list
 }
 
-//Sort sorts events by time
-func Sort() {
-	sort.Sort(ByTime(Events))
+//Newest returns the newest event
+func (s *Store) Newest() (Event, bool) {
+	err := s.Save()
+	if err != nil {
+		log.Println(err)
+		return Event{}, false
+	}
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	var id []byte
+	s.db.View(func(tx *bolt.Tx) error {
+		_, v := tx.Bucket(timeBucket).Cursor().Last()
+		id = append(id, v...)
+		return nil
+	})
+	if id == nil {
+		return Event{}, false
+	}
+	return s.get(string(id))
 }
 
 //ErrorEvents returns a list of events with errors
-func ErrorEvents() []Event {
+func (s *Store) ErrorEvents() []Event {
 	list := make([]Event, 0)
-	for _, e := range Events {
+	err := s.Each(func(e Event) error {
 		if e.HasError() {
 			list = append(list, e)
 		}
+		return nil
+	})
+	if err != nil {
+		log.Println(err)
 	}
 	return list
 }
 
-const eventsFilename = "korra.events.json"
+//Save writes the events added since the last save, and returns the first error of a write
+//made while events were added
+func (s *Store) Save() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.setErr(s.flush())
+	err := s.err
+	s.err = nil
+	return err
+}
 
-//LoadFromFile loads events from file
-func LoadFromFile() error {
-	_, err := os.Stat(eventsFilename)
-	if os.IsNotExist(err) {
+//CopyFile saves the events and writes a consistent copy of the database to path
+func (s *Store) CopyFile(path string) error {
+	err := s.Save()
+	if err != nil {
+		return err
+	}
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.db.View(func(tx *bolt.Tx) error {
+		return tx.CopyFile(path, 0644)
+	})
+}
+
+//Close saves the events and closes the database, the store cannot be used after it is closed
+func (s *Store) Close() error {
+	if s.closed() {
 		return nil
 	}
-	data, err := ioutil.ReadFile(eventsFilename)
+	err := s.Save()
 	if err != nil {
 		return err
 	}
-	return json.Unmarshal(data, &Events)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	err = s.db.Close()
+	s.db = nil
+	return err
 }
 
-//SaveToFile persists all events to a local file
-func SaveToFile() error {
-	data, err := json.Marshal(Events)
+func (s *Store) closed() bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.db == nil
+}
+
+//timeKey returns the key of an event in the time index, events of the same time are ordered by ID
+func timeKey(e Event) []byte {
+	return []byte(e.Time.UTC().Format("2006-01-02T15:04:05.000000000Z") + e.ID)
+}
+
+//encodeEvent compresses the raw record of an event with its account and region, or the whole
+//event if it has no raw record. Other changes made to an event after it was decoded from its raw
+//record are not kept.
+func encodeEvent(e Event) ([]byte, error) {
+	var buf bytes.Buffer
+	data := []byte(e.RawEvent)
+	if e.RawEvent == "" {
+		buf.WriteByte(kindEvent)
+		var err error
+		data, err = json.Marshal(e)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		buf.WriteByte(kindRaw)
+		writeString(&buf, e.RecipientAccountID)
+		writeString(&buf, e.Region)
+	}
+	w := flateWriters.Get().(*flate.Writer)
+	defer flateWriters.Put(w)
+	w.Reset(&buf)
+	_, err := w.Write(data)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	err = w.Close()
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+//decodeEvent decompresses an event written by encodeEvent
+func decodeEvent(value []byte) (Event, error) {
+	if len(value) == 0 {
+		return Event{}, fmt.Errorf("Empty stored event")
+	}
+	kind := value[0]
+	value = value[1:]
+	var account, region string
+	var err error
+	if kind == kindRaw {
+		account, value, err = readString(value)
+		if err != nil {
+			return Event{}, err
+		}
+		region, value, err = readString(value)
+		if err != nil {
+			return Event{}, err
+		}
+	}
+	r := flate.NewReader(bytes.NewReader(value))
+	defer r.Close()
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return Event{}, err
+	}
+	if kind == kindRaw {
+		e, err := NewEvent(data)
+		e.RecipientAccountID = account
+		e.Region = region
+		return e, err
+	}
+	var e Event
+	err = json.Unmarshal(data, &e)
+	return e, err
+}
+
+//writeString writes a string prefixed by its length
+func writeString(buf *bytes.Buffer, value string) {
+	var size [binary.MaxVarintLen64]byte
+	buf.Write(size[:binary.PutUvarint(size[:], uint64(len(value)))])
+	buf.WriteString(value)
+}
+
+//readString reads a string written by writeString, returns the rest of data
+func readString(data []byte) (string, []byte, error) {
+	size, n := binary.Uvarint(data)
+	if n <= 0 || uint64(len(data)-n) < size {
+		return "", nil, fmt.Errorf("Invalid stored event")
 	}
-	return ioutil.WriteFile(eventsFilename, data, 0644)
+	data = data[n:]
+	return string(data[:size]), data[size:], nil
 }
@@ -0,0 +1,107 @@
+package cloudtrail
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "korra-store")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	//events of the JSON file of older versions kept only some of their fields
+	start := time.Date(2018, 10, 30, 0, 0, 0, 0, time.UTC)
+	legacy := make([]Event, 0)
+	for i := 0; i < flushSize+10; i++ {
+		raw := fmt.Sprintf(`{"eventID":"%v","eventName":"GetObject","eventTime":"%v","readOnly":true}`,
+			i, start.Add(-time.Duration(i)*time.Minute).Format(time.RFC3339))
+		legacy = append(legacy, Event{ID: fmt.Sprintf("%v", i), RawEvent: raw})
+	}
+	data, err := json.Marshal(legacy)
+	assert.NoError(t, err)
+	jsonPath := filepath.Join(dir, "events.json")
+	assert.NoError(t, ioutil.WriteFile(jsonPath, data, 0644))
+
+	s := NewStore(filepath.Join(dir, "events.db"))
+	assert.NoError(t, s.Open())
+	added, err := s.Migrate(jsonPath)
+	assert.NoError(t, err)
+	assert.EqualValues(t, len(legacy), added)
+	assert.FileExists(t, jsonPath+".migrated")
+	added, err = s.Migrate(jsonPath)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 0, added)
+
+	//events are iterated in time order
+	events := s.Events()
+	assert.Len(t, events, len(legacy))
+	assert.EqualValues(t, fmt.Sprintf("%v", len(legacy)-1), events[0].ID)
+	assert.True(t, events[0].ReadOnly)
+	newest, ok := s.Newest()
+	assert.True(t, ok)
+	assert.EqualValues(t, "0", newest.ID)
+
+	//an event without a raw record is kept as is, replacing an event moves it in time
+	_, ok = s.Get("5")
+	assert.True(t, ok)
+	changed := Event{ID: "5", Name: "GetObject", Region: "eu-west-1", Time: start.Add(time.Hour)}
+	s.AddEvent(changed)
+	assert.False(t, s.AddNew(changed))
+	assert.NoError(t, s.Close())
+
+	s = NewStore(filepath.Join(dir, "events.db"))
+	assert.NoError(t, s.Open())
+	defer s.Close()
+	assert.EqualValues(t, len(legacy), s.Len())
+	newest, _ = s.Newest()
+	assert.EqualValues(t, changed, newest)
+
+	s.Clear()
+	assert.EqualValues(t, 0, s.Len())
+	assert.Empty(t, s.Events())
+	_, ok = s.Get("5")
+	assert.False(t, ok)
+}
+
+func TestEncodeEvent(t *testing.T) {
+	records := []string{
+		`{"eventID":"1","eventName":"AssumeRole","eventTime":"2018-10-30T10:00:00Z","awsRegion":"us-east-1",
+			"recipientAccountId":"123","userIdentity":{"type":"IAMUser","arn":"arn:aws:iam::123:user/alice"},
+			"requestParameters":{"roleArn":"arn:aws:iam::123:role/admin"},"readOnly":false}`,
+		`{"eventID":"2","eventName":"GetObject","eventTime":"2018-10-30T11:00:00Z","managementEvent":false,
+			"additionalEventData":{"bytesTransferredOut":42},"tlsDetails":{"tlsVersion":"TLSv1.2"}}`,
+	}
+	for _, record := range records {
+		e, err := NewEvent([]byte(record))
+		assert.NoError(t, err)
+		//the account and region set by loaders are kept with the raw record
+		for _, account := range []string{e.RecipientAccountID, "456"} {
+			e.RecipientAccountID = account
+			e.Region = "eu-west-1"
+			value, err := encodeEvent(e)
+			assert.NoError(t, err)
+			assert.EqualValues(t, kindRaw, value[0])
+			decoded, err := decodeEvent(value)
+			assert.NoError(t, err)
+			assert.EqualValues(t, e, decoded)
+		}
+	}
+
+	e := Event{ID: "3", Name: "GetObject", Time: time.Date(2018, 10, 30, 0, 0, 0, 0, time.UTC)}
+	value, err := encodeEvent(e)
+	assert.NoError(t, err)
+	assert.EqualValues(t, kindEvent, value[0])
+	decoded, err := decodeEvent(value)
+	assert.NoError(t, err)
+	assert.EqualValues(t, e, decoded)
+	_, err = decodeEvent([]byte{kindRaw, 10})
+	assert.Error(t, err)
+}
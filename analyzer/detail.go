@@ -0,0 +1,209 @@
+package analyzer
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/dtylman/korra/analyzer/assumerole"
+	"github.com/dtylman/korra/analyzer/cloudtrail"
+)
+
+//neighbourEvents is the number of events of the same principal shown before and after an event
+const neighbourEvents = 5
+
+//Field is a decoded field of an event, nested fields are named by their path
+type Field struct {
+	Name  string
+	Value string
+}
+
+//Pivot is a search for events sharing a value with an event
+type Pivot struct {
+	Label string
+	Field string
+	Value string
+}
+
+//Options returns the search options of the pivot, newest events first
+func (p Pivot) Options() SearchOptions {
+	return SearchOptions{Sort: SortNewest}.Refine(p.Field, p.Value)
+}
+
+//EventDetail is everything known about a single event
+type EventDetail struct {
+	Event cloudtrail.Event
+	//Raw is the pretty printed original CloudTrail record
+	Raw string
+	//Identity holds the fields of the userIdentity of the record, including its sessionContext
+	Identity []Field
+	//Session is the assume role session the event belongs to, nil if none
+	Session *assumerole.Session
+	//Neighbours are the events of the same principal around the event, in time order, including the event
+	Neighbours []cloudtrail.Event
+	Pivots     []Pivot
+}
+
+//Detail returns the detail of the event with the given ID
+func (e *Engine) Detail(id string) (EventDetail, error) {
+	event, ok := e.Events.Get(id)
+	if !ok {
+		return EventDetail{}, fmt.Errorf("Event '%v' not found", id)
+	}
+	d := EventDetail{Event: event}
+	var err error
+	d.Raw, err = event.RawJSONString("", "  ")
+	if err != nil {
+		return d, err
+	}
+	d.Identity, err = identityFields(event)
+	if err != nil {
+		return d, err
+	}
+	sess, ok := e.Sessions.Issuer(event)
+	if event.Name == "AssumeRole" {
+		sess, ok = e.Sessions.Get(event.BuildAssumedRoleARN())
+	}
+	if ok {
+		d.Session = &sess
+	}
+	d.Neighbours, err = e.neighbours(event, neighbourEvents)
+	if err != nil {
+		return d, err
+	}
+	d.Pivots = pivots(event)
+	return d, nil
+}
+
+//identityFields returns the fields of the userIdentity of an event, including its sessionContext
+func identityFields(event cloudtrail.Event) ([]Field, error) {
+	data, err := json.Marshal(event.UserIdentity)
+	if err != nil {
+		return nil, err
+	}
+	var identity map[string]interface{}
+	err = json.Unmarshal(data, &identity)
+	if err != nil {
+		return nil, err
+	}
+	fields := make([]Field, 0)
+	flatten("", identity, &fields)
+	sort.Slice(fields, func(i, j int) bool { return fields[i].Name < fields[j].Name })
+	return fields, nil
+}
+
+//flatten adds the values of a decoded JSON object to fields, named by their path
+func flatten(prefix string, m map[string]interface{}, fields *[]Field) {
+	for k, v := range m {
+		name := k
+		if prefix != "" {
+			name = prefix + "." + k
+		}
+		if sub, ok := v.(map[string]interface{}); ok {
+			flatten(name, sub, fields)
+			continue
+		}
+		*fields = append(*fields, Field{Name: name, Value: fmt.Sprintf("%v", v)})
+	}
+}
+
+//principal returns the ARN, or the access key, that identifies who made the event
+func principal(event cloudtrail.Event) (string, string) {
+	if event.UserIdentity.ARN != "" {
+		return "arn", event.UserIdentity.ARN
+	}
+	return "accessKeyId", event.UserIdentity.AccessKeyID
+}
+
+//neighbours returns up to n events before and after event that have the same principal, in time order.
+//The events are searched in the index, or read from all events if the engine has no search index.
+func (e *Engine) neighbours(event cloudtrail.Event, n int) ([]cloudtrail.Event, error) {
+	kind, value := principal(event)
+	if value == "" {
+		return []cloudtrail.Event{event}, nil
+	}
+	if e.Indexer == nil {
+		return scanNeighbours(e.Events, event, n)
+	}
+	field := "userIdentity." + kind
+	same := []cloudtrail.Event{event}
+	seen := map[string]bool{event.ID: true}
+	for _, opts := range []SearchOptions{
+		{End: event.Time, Sort: SortNewest, Size: n + 1},
+		{Start: event.Time, Sort: SortOldest, Size: n + 1},
+	} {
+		req, err := opts.Refine(field, value).Request()
+		if err != nil {
+			return nil, err
+		}
+		sr, err := e.Indexer.Search(req)
+		if err != nil {
+			return nil, err
+		}
+		for _, hit := range sr.Hits {
+			other, ok := e.Events.Get(hit.ID)
+			if ok && !seen[hit.ID] {
+				seen[hit.ID] = true
+				same = append(same, other)
+			}
+		}
+	}
+	sort.Slice(same, func(i, j int) bool {
+		if same[i].Time.Equal(same[j].Time) {
+			return same[i].ID < same[j].ID
+		}
+		return same[i].Time.Before(same[j].Time)
+	})
+	return around(same, event, n), nil
+}
+
+//scanNeighbours returns the neighbours of an event by reading all events
+func scanNeighbours(events eventSource, event cloudtrail.Event, n int) ([]cloudtrail.Event, error) {
+	kind, value := principal(event)
+	same := make([]cloudtrail.Event, 0)
+	err := events.Each(func(other cloudtrail.Event) error {
+		k, v := principal(other)
+		if k == kind && v == value {
+			same = append(same, other)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return around(same, event, n), nil
+}
+
+//around returns up to n events before and after event in a list of events in time order
+func around(events []cloudtrail.Event, event cloudtrail.Event, n int) []cloudtrail.Event {
+	at := 0
+	for i, other := range events {
+		if other.ID == event.ID {
+			at = i
+		}
+	}
+	from, to := at-n, at+n+1
+	if from < 0 {
+		from = 0
+	}
+	if to > len(events) {
+		to = len(events)
+	}
+	return events[from:to]
+}
+
+//pivots returns searches on the source IP, access key and ARN of an event
+func pivots(event cloudtrail.Event) []Pivot {
+	list := make([]Pivot, 0)
+	add := func(label, field, value string) {
+		if value != "" {
+			list = append(list, Pivot{Label: label, Field: field, Value: value})
+		}
+	}
+	add("Source IP", "sourceIPAddress", event.SourceIPAddress)
+	add("Access key", "userIdentity.accessKeyId", event.UserIdentity.AccessKeyID)
+	add("ARN", "userIdentity.arn", event.UserIdentity.ARN)
+	add("Assumed role", "responseElements.assumedRoleUser.arn", event.ResponseElements.String("assumedRoleUser", "arn"))
+	add("Issued access key", "responseElements.credentials.accessKeyId", event.ResponseElements.String("credentials", "accessKeyId"))
+	return list
+}
@@ -0,0 +1,74 @@
+package analyzer
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dtylman/korra/analyzer/cloudtrail"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEngine_Detail(t *testing.T) {
+	e, dir, cleanup := newTestEngine(t, "")
+	defer cleanup()
+	start := time.Date(2018, 10, 30, 0, 0, 0, 0, time.UTC)
+	raw := `{"eventID":"%v","eventName":"GetObject","eventTime":"%v","sourceIPAddress":"10.0.0.1",
+		"userIdentity":{"type":"AssumedRole","arn":"%v","accessKeyId":"ASIA1",
+		"sessionContext":{"attributes":{"mfaAuthenticated":"false"},"sessionIssuer":{"userName":"admin"}}}}`
+	for i := 0; i < 20; i++ {
+		arn := "arn:aws:sts::1:assumed-role/admin/a"
+		if i%2 == 1 {
+			arn = "arn:aws:sts::1:assumed-role/admin/b"
+		}
+		event, err := cloudtrail.NewEvent([]byte(fmt.Sprintf(raw, i, start.Add(time.Duration(i)*time.Minute).Format(time.RFC3339), arn)))
+		assert.NoError(t, err)
+		e.Events.AddEvent(event)
+	}
+
+	assumeRole, err := cloudtrail.NewEvent([]byte(`{"eventID":"assume","eventName":"AssumeRole","eventTime":"2018-10-29T00:00:00Z",
+		"requestParameters":{"roleArn":"arn:aws:iam::1:role/admin","roleSessionName":"x"},
+		"responseElements":{"credentials":{"accessKeyId":"ASIA1"},"assumedRoleUser":{"arn":"arn:aws:sts::1:assumed-role/admin/x"}}}`))
+	assert.NoError(t, err)
+	e.Events.AddEvent(assumeRole)
+	e.buildSessions(e.Events)
+
+	_, err = e.Detail("missing")
+	assert.Error(t, err)
+	d, err := e.Detail("2")
+	assert.NoError(t, err)
+	assert.Contains(t, d.Raw, "\n  \"eventID\": \"2\"")
+	assert.Contains(t, d.Identity, Field{"sessionContext.attributes.mfaAuthenticated", "false"})
+	assert.Contains(t, d.Identity, Field{"sessionContext.sessionIssuer.userName", "admin"})
+	//the session is found by the access key it issued, the session name differs from the ARN
+	assert.NotNil(t, d.Session)
+	assert.EqualValues(t, "arn:aws:sts::1:assumed-role/admin/x", d.Session.AssumedRoleARN)
+	ids := make([]string, 0)
+	for _, n := range d.Neighbours {
+		ids = append(ids, n.ID)
+	}
+	assert.EqualValues(t, []string{"0", "2", "4", "6", "8", "10", "12"}, ids)
+	assert.Len(t, d.Pivots, 3)
+	assert.EqualValues(t, map[string]string{"sourceIPAddress": "10.0.0.1"}, d.Pivots[0].Options().Filters)
+	assert.NoError(t, e.Close())
+
+	//with a search index the neighbours are searched in it
+	e, err = NewEngine(filepath.Join(dir, "events.db"), filepath.Join(dir, "korra.db"))
+	require.NoError(t, err)
+	defer e.Close()
+	assert.NoError(t, e.Reindex(context.Background(), nil))
+	d, err = e.Detail("2")
+	assert.NoError(t, err)
+	indexed := make([]string, 0)
+	for _, n := range d.Neighbours {
+		indexed = append(indexed, n.ID)
+	}
+	assert.EqualValues(t, ids, indexed)
+	d, err = e.Detail("19")
+	assert.NoError(t, err)
+	assert.Len(t, d.Neighbours, neighbourEvents+1)
+	assert.EqualValues(t, "19", d.Neighbours[neighbourEvents].ID)
+}
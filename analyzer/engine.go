@@ -0,0 +1,184 @@
+package analyzer
+
+import (
+	"log"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/dtylman/korra/analyzer/assumerole"
+	"github.com/dtylman/korra/analyzer/cloudtrail"
+	"github.com/dtylman/korra/analyzer/findings"
+)
+
+//Engine owns a set of events, the sessions built from them and the analyzers that run on them.
+//It is safe for concurrent use, loads and analysis runs are serialized.
+type Engine struct {
+	//Events holds the loaded events
+	Events *cloudtrail.Store
+	//Sessions holds the assume role sessions built from the events
+	Sessions *assumerole.Sessions
+	//Findings holds the findings reported by the analyzers
+	Findings *findings.List
+	//Checkpoints holds the newest event loaded from every account and region
+	Checkpoints *Checkpoints
+	//Searches holds the saved searches and hunts
+	Searches *SavedSearches
+	//Indexer is the search index, nil if the engine was created without one
+	Indexer *BleveAnalyzer
+
+	mutex     sync.RWMutex
+	options   Options
+	analyzers []Analyzer
+	targets   []Target
+	stats     []AnalyzerStats
+	running   sync.Mutex
+	//base is the path of the event store without its extension, other files are named after it
+	base string
+}
+
+//NewEngine creates an engine with events stored in the database at eventsPath and opens it,
+//events of the JSON file written by older versions (korra.events.db -> korra.events.json) are
+//migrated once. Findings, checkpoints and saved searches are persisted next to the events
+//(korra.events.findings.json, korra.events.checkpoints.json, korra.events.searches.json).
+//If indexPath is not empty, events are also indexed for search at indexPath, see IndexDrift
+//to find out whether the index matches the events.
+func NewEngine(eventsPath string, indexPath string) (*Engine, error) {
+	base := strings.TrimSuffix(eventsPath, filepath.Ext(eventsPath))
+	e := &Engine{
+		Events:      cloudtrail.NewStore(eventsPath),
+		Sessions:    assumerole.NewSessions(),
+		Findings:    findings.NewList(base + ".findings.json"),
+		Checkpoints: NewCheckpoints(base + ".checkpoints.json"),
+		Searches:    NewSavedSearches(base + ".searches.json"),
+		analyzers:   make([]Analyzer, 0),
+		base:        base,
+	}
+	err := e.Events.Open()
+	if err != nil {
+		return nil, err
+	}
+	err = e.open(indexPath)
+	if err != nil {
+		e.Events.Close()
+		return nil, err
+	}
+	return e, nil
+}
+
+//open migrates the events and loads the other files of an engine whose event store is open
+func (e *Engine) open(indexPath string) error {
+	_, err := e.Events.Migrate(e.base + ".json")
+	if err != nil {
+		return err
+	}
+	err = e.Findings.Load()
+	if err != nil {
+		return err
+	}
+	err = e.Checkpoints.Load()
+	if err != nil {
+		return err
+	}
+	err = e.Searches.Load()
+	if err != nil {
+		return err
+	}
+	e.buildSessions(e.Events)
+	e.AddAnalyzer(assumerole.NewSessionAnalyzer(e.Sessions, e))
+	if indexPath != "" {
+		e.Indexer, err = openIndex(indexPath)
+		if err != nil {
+			return err
+		}
+		e.AddAnalyzer(e.Indexer)
+	}
+	return nil
+}
+
+//Options returns a copy of the engine options
+func (e *Engine) Options() Options {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+	return e.options
+}
+
+//SetOptions sets the options used by the next load
+func (e *Engine) SetOptions(options Options) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.options = options
+}
+
+//AddAnalyzer adds an analyzer
+func (e *Engine) AddAnalyzer(a Analyzer) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.analyzers = append(e.analyzers, a)
+}
+
+//Analyzers returns the list of analyzers
+func (e *Engine) Analyzers() []Analyzer {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+	list := make([]Analyzer, len(e.analyzers))
+	copy(list, e.analyzers)
+	return list
+}
+
+//Clear resets all events, sessions, checkpoints and analyzers
+func (e *Engine) Clear() {
+	e.running.Lock()
+	defer e.running.Unlock()
+	e.clear()
+}
+
+func (e *Engine) clear() {
+	e.Events.Clear()
+	e.Sessions.Clear()
+	e.Findings.Clear()
+	e.Checkpoints.Clear()
+	for _, a := range e.Analyzers() {
+		err := a.Clear()
+		if err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+//Report adds a finding reported by an analyzer
+func (e *Engine) Report(f findings.Finding) {
+	e.Findings.Report(f)
+}
+
+//Save persists the events, findings, checkpoints and saved searches
+func (e *Engine) Save() error {
+	err := e.Events.Save()
+	if err != nil {
+		return err
+	}
+	err = e.Findings.Save()
+	if err != nil {
+		return err
+	}
+	err = e.Checkpoints.Save()
+	if err != nil {
+		return err
+	}
+	return e.Searches.Save()
+}
+
+//Close closes the event store and the search index
+func (e *Engine) Close() error {
+	e.running.Lock()
+	defer e.running.Unlock()
+	err := e.Events.Close()
+	if e.Indexer == nil {
+		return err
+	}
+	ierr := e.Indexer.Close()
+	if err != nil {
+		return err
+	}
+	return ierr
+}
@@ -0,0 +1,111 @@
+package analyzer
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/dtylman/korra/analyzer/cloudtrail"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+//newTestEngine creates an engine in a temporary directory, with a search index of that name in it
+//unless index is empty. Returns the directory and a cleanup closing the engine and removing it.
+func newTestEngine(t *testing.T, index string) (*Engine, string, func()) {
+	dir, err := ioutil.TempDir("", "korra-engine")
+	require.NoError(t, err)
+	indexPath := ""
+	if index != "" {
+		indexPath = filepath.Join(dir, index)
+	}
+	e, err := NewEngine(filepath.Join(dir, "events.db"), indexPath)
+	if err != nil {
+		os.RemoveAll(dir)
+	}
+	require.NoError(t, err)
+	return e, dir, func() {
+		assert.NoError(t, e.Close())
+		os.RemoveAll(dir)
+	}
+}
+
+func TestEngine_Analyze(t *testing.T) {
+	e, dir, cleanup := newTestEngine(t, "")
+	defer cleanup()
+	other, _, cleanupOther := newTestEngine(t, "")
+	defer cleanupOther()
+
+	arn := "arn:aws:sts::789433625753:assumed-role/trailblazer/createsecuritygroup"
+	assume := cloudtrail.Event{ID: "0", Name: "AssumeRole", SourceIPAddress: "1.1.1.1"}
+	assume.ResponseElements = cloudtrail.Parameters{"assumedRoleUser": map[string]interface{}{"arn": arn}}
+	e.Events.AddEvent(assume)
+	for i := 1; i < 100; i++ {
+		event := cloudtrail.Event{ID: fmt.Sprintf("%v", i), SourceIPAddress: "2.2.2.2"}
+		event.UserIdentity.ARN = arn
+		e.Events.AddEvent(event)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		assert.NoError(t, e.Analyze(context.Background(), nil))
+	}()
+	for i := 0; i < 100; i++ {
+		e.Findings.All()
+		e.Sessions.List()
+		e.Events.ErrorEvents()
+		e.SetOptions(e.Options())
+	}
+	wg.Wait()
+
+	_, ok := e.Sessions.Get(arn)
+	assert.True(t, ok)
+	list := e.Findings.All()
+	if assert.Len(t, list, 1) {
+		assert.Len(t, list[0].EventIDs, 99)
+		assert.EqualValues(t, arn, list[0].SessionARN)
+	}
+
+	assert.NoError(t, e.Save())
+	assert.NoError(t, e.Close())
+	reopened, err := NewEngine(filepath.Join(dir, "events.db"), "")
+	require.NoError(t, err)
+	defer reopened.Close()
+	assert.EqualValues(t, 100, reopened.Events.Len())
+	assert.EqualValues(t, list, reopened.Findings.All())
+	assert.EqualValues(t, 0, other.Sessions.Len())
+	assert.EqualValues(t, 0, other.Events.Len())
+}
+
+func TestEngine_AnalyzeCancelled(t *testing.T) {
+	e, _, cleanup := newTestEngine(t, "")
+	defer cleanup()
+	e.Events.AddEvent(cloudtrail.Event{ID: "1"})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, context.Canceled, e.Analyze(ctx, nil))
+	assert.EqualValues(t, 1, e.Events.Len())
+}
+
+func TestNewEngine_ClosesStoreOnError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "korra-engine")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "events.db")
+	findingsPath := filepath.Join(dir, "events.findings.json")
+	assert.NoError(t, ioutil.WriteFile(findingsPath, []byte("{"), 0644))
+	_, err = NewEngine(path, "")
+	assert.Error(t, err)
+
+	//the event store is not left locked
+	assert.NoError(t, os.Remove(findingsPath))
+	e, err := NewEngine(path, "")
+	assert.NoError(t, err)
+	assert.NoError(t, e.Close())
+}
@@ -0,0 +1,168 @@
+package analyzer
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/search"
+)
+
+//FacetFields are the fields counted by term facets
+var FacetFields = []string{"eventName", "eventSource", "userIdentity.arn", "sourceIPAddress", "awsRegion", "errorCode",
+	"readOnly", "eventCategory", "vpcEndpointId", "tlsDetails.tlsVersion"}
+
+//HistogramFacet is the name of the eventTime date histogram facet
+const HistogramFacet = "histogram"
+
+const (
+	facetSize        = 10
+	histogramBuckets = 48
+)
+
+//histogramSteps are the bucket widths of the histogram, the smallest giving at most histogramBuckets is used
+var histogramSteps = []time.Duration{
+	time.Minute, 5 * time.Minute, 15 * time.Minute, time.Hour, 3 * time.Hour, 6 * time.Hour,
+	24 * time.Hour, 7 * 24 * time.Hour, 30 * 24 * time.Hour,
+}
+
+//Bucket is a bar of the time histogram
+type Bucket struct {
+	Start time.Time
+	End   time.Time
+	Count int
+}
+
+//Refine returns the options with hits limited to events where field has the term
+func (o SearchOptions) Refine(field, term string) SearchOptions {
+	filters := make(map[string]string)
+	for k, v := range o.Filters {
+		filters[k] = v
+	}
+	filters[field] = term
+	o.Filters = filters
+	o.From = 0
+	return o
+}
+
+//Unrefine returns the options without the filter on field
+func (o SearchOptions) Unrefine(field string) SearchOptions {
+	filters := make(map[string]string)
+	for k, v := range o.Filters {
+		if k != field {
+			filters[k] = v
+		}
+	}
+	o.Filters = filters
+	o.From = 0
+	return o
+}
+
+//TimeSpan returns the times of the oldest and newest indexed events
+func (ba *BleveAnalyzer) TimeSpan() (time.Time, time.Time, error) {
+	var span [2]time.Time
+	for i, order := range []string{timeField, "-" + timeField} {
+		req := bleve.NewSearchRequestOptions(bleve.NewMatchAllQuery(), 1, 0, false)
+		req.Fields = []string{timeField}
+		req.SortBy([]string{order})
+		sr, err := ba.Search(req)
+		if err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+		if len(sr.Hits) == 0 {
+			return time.Time{}, time.Time{}, nil
+		}
+		value, _ := sr.Hits[0].Fields[timeField].(string)
+		span[i], err = time.Parse(time.RFC3339, value)
+		if err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+	}
+	return span[0], span[1], nil
+}
+
+//AddFacets adds the term facets of FacetFields and the eventTime histogram to the request.
+//The histogram covers the time range of the options, or of the indexed events if not set.
+func (ba *BleveAnalyzer) AddFacets(req *bleve.SearchRequest, o SearchOptions) error {
+	for _, field := range FacetFields {
+		req.AddFacet(field, bleve.NewFacetRequest(field, facetSize))
+	}
+	first, last, err := ba.TimeSpan()
+	if err != nil {
+		return err
+	}
+	if !o.Start.IsZero() {
+		first = o.Start
+	}
+	if !o.End.IsZero() {
+		last = o.End
+	}
+	buckets := histogram(first, last)
+	if len(buckets) == 0 {
+		return nil
+	}
+	fr := bleve.NewFacetRequest(timeField, len(buckets))
+	for _, b := range buckets {
+		fr.AddDateTimeRange(b.Start.Format(time.RFC3339), b.Start, b.End)
+	}
+	req.AddFacet(HistogramFacet, fr)
+	return nil
+}
+
+//histogram splits first..last into empty buckets
+func histogram(first, last time.Time) []Bucket {
+	if first.IsZero() || last.Before(first) {
+		return nil
+	}
+	step := histogramSteps[len(histogramSteps)-1]
+	for _, s := range histogramSteps {
+		if last.Sub(first.Truncate(s)) < time.Duration(histogramBuckets)*s {
+			step = s
+			break
+		}
+	}
+	buckets := make([]Bucket, 0)
+	for t := first.Truncate(step); !t.After(last); t = t.Add(step) {
+		buckets = append(buckets, Bucket{Start: t.UTC(), End: t.Add(step).UTC()})
+	}
+	return buckets
+}
+
+//Terms returns the term facet of field in a search result, without the empty term of events
+//that do not have the field. Terms of boolean fields are "true" and "false".
+func Terms(sr *bleve.SearchResult, field string) search.TermFacets {
+	terms := make(search.TermFacets, 0)
+	fr, ok := sr.Facets[field]
+	if !ok {
+		return terms
+	}
+	for _, t := range fr.Terms {
+		if t.Term == "" {
+			continue
+		}
+		if isBoolField(field) {
+			t = &search.TermFacet{Term: strconv.FormatBool(t.Term == "T"), Count: t.Count}
+		}
+		terms = append(terms, t)
+	}
+	return terms
+}
+
+//Histogram returns the buckets of the histogram facet of a search result in time order,
+//including empty buckets
+func Histogram(sr *bleve.SearchResult) []Bucket {
+	if sr.Request == nil || sr.Request.Facets[HistogramFacet] == nil {
+		return nil
+	}
+	counts := make(map[string]int)
+	if fr, ok := sr.Facets[HistogramFacet]; ok {
+		for _, dr := range fr.DateRanges {
+			counts[dr.Name] = dr.Count
+		}
+	}
+	buckets := make([]Bucket, 0)
+	for _, dr := range sr.Request.Facets[HistogramFacet].DateTimeRanges {
+		buckets = append(buckets, Bucket{Start: dr.Start, End: dr.End, Count: counts[dr.Name]})
+	}
+	return buckets
+}
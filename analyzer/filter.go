@@ -0,0 +1,116 @@
+package analyzer
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudtrail"
+	cloudtrailevents "github.com/dtylman/korra/analyzer/cloudtrail"
+)
+
+//LookupAttributes lists the attribute keys events can be filtered by when loading
+var LookupAttributes = []string{
+	cloudtrail.LookupAttributeKeyEventName,
+	cloudtrail.LookupAttributeKeyUsername,
+	cloudtrail.LookupAttributeKeyResourceType,
+	cloudtrail.LookupAttributeKeyResourceName,
+	cloudtrail.LookupAttributeKeyEventSource,
+	cloudtrail.LookupAttributeKeyAccessKeyId,
+	cloudtrail.LookupAttributeKeyReadOnly,
+}
+
+//timeLayouts are the layouts accepted by ParseTime
+var timeLayouts = []string{time.RFC3339, "2006-01-02T15:04", "2006-01-02 15:04", "2006-01-02"}
+
+//ParseTime parses a time given as RFC3339, "2006-01-02T15:04" (as sent by datetime inputs) or a date.
+//Times without a zone are UTC, an empty string is the zero time.
+func ParseTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	for _, layout := range timeLayouts {
+		t, err := time.Parse(layout, value)
+		if err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("Cannot parse time '%v', expected RFC3339 or YYYY-MM-DD[THH:MM]", value)
+}
+
+//ParseAttribute parses a "Key=Value" lookup attribute filter
+func ParseAttribute(filter string) (string, string, error) {
+	parts := strings.SplitN(filter, "=", 2)
+	if len(parts) == 2 {
+		for _, key := range LookupAttributes {
+			if strings.EqualFold(key, parts[0]) {
+				return key, parts[1], nil
+			}
+		}
+	}
+	return "", "", fmt.Errorf("Invalid attribute filter '%v', expected Key=Value with Key one of %v", filter, strings.Join(LookupAttributes, ", "))
+}
+
+//lookupAttribute returns the attribute filter sent to LookupEvents, which accepts only one.
+//The other attributes are matched on the loaded events.
+func (o *Options) lookupAttribute() []*cloudtrail.LookupAttribute {
+	for _, key := range LookupAttributes {
+		value, ok := o.Attributes[key]
+		if ok {
+			return []*cloudtrail.LookupAttribute{{
+				AttributeKey:   aws.String(key),
+				AttributeValue: aws.String(value),
+			}}
+		}
+	}
+	return nil
+}
+
+//filtered returns true if the options select only some of the events after a checkpoint
+func (o *Options) filtered() bool {
+	return len(o.Attributes) > 0 || !o.EndTime.IsZero()
+}
+
+//match returns true if the event is within the time range and matches all attribute filters
+func (o *Options) match(e cloudtrailevents.Event) bool {
+	if !o.inTimeRange(e.Time) {
+		return false
+	}
+	for key, value := range o.Attributes {
+		if !matchAttribute(e, key, value) {
+			return false
+		}
+	}
+	return true
+}
+
+func matchAttribute(e cloudtrailevents.Event, key, value string) bool {
+	switch key {
+	case cloudtrail.LookupAttributeKeyEventName:
+		return e.Name == value
+	case cloudtrail.LookupAttributeKeyUsername:
+		return e.UserIdentity.UserName == value || strings.HasSuffix(e.UserIdentity.ARN, "/"+value)
+	case cloudtrail.LookupAttributeKeyEventSource:
+		return e.Source == value
+	case cloudtrail.LookupAttributeKeyAccessKeyId:
+		return e.UserIdentity.AccessKeyID == value
+	case cloudtrail.LookupAttributeKeyReadOnly:
+		readOnly, err := strconv.ParseBool(value)
+		return err == nil && e.ReadOnly == readOnly
+	case cloudtrail.LookupAttributeKeyResourceType:
+		for _, r := range e.Resources {
+			if r.Type == value {
+				return true
+			}
+		}
+	case cloudtrail.LookupAttributeKeyResourceName:
+		for _, r := range e.Resources {
+			if r.ARN == value || strings.HasSuffix(r.ARN, ":"+value) || strings.HasSuffix(r.ARN, "/"+value) {
+				return true
+			}
+		}
+	}
+	return false
+}
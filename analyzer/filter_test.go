@@ -0,0 +1,50 @@
+package analyzer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dtylman/korra/analyzer/cloudtrail"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTime(t *testing.T) {
+	expected := time.Date(2018, 10, 29, 11, 3, 0, 0, time.UTC)
+	for _, value := range []string{"2018-10-29T11:03:00Z", "2018-10-29T11:03", "2018-10-29 11:03"} {
+		parsed, err := ParseTime(value)
+		assert.NoError(t, err)
+		assert.True(t, expected.Equal(parsed), value)
+	}
+	parsed, err := ParseTime("")
+	assert.NoError(t, err)
+	assert.True(t, parsed.IsZero())
+	_, err = ParseTime("yesterday")
+	assert.Error(t, err)
+}
+
+func TestOptions_Match(t *testing.T) {
+	key, value, err := ParseAttribute("eventname=AssumeRole")
+	assert.NoError(t, err)
+	assert.EqualValues(t, "EventName", key)
+	assert.EqualValues(t, "AssumeRole", value)
+	_, _, err = ParseAttribute("Color=red")
+	assert.Error(t, err)
+
+	e := cloudtrail.Event{Name: "AssumeRole", ReadOnly: true, Time: time.Date(2018, 10, 29, 11, 3, 0, 0, time.UTC)}
+	e.UserIdentity.ARN = "arn:aws:iam::789433625753:user/danny"
+	e.Resources = []cloudtrail.Resource{{ARN: "arn:aws:iam::789433625753:role/trailblazer", Type: "AWS::IAM::Role"}}
+
+	opts := Options{Attributes: map[string]string{"EventName": "AssumeRole", "Username": "danny", "ReadOnly": "true"}}
+	assert.True(t, opts.match(e))
+	assert.True(t, opts.filtered())
+	assert.EqualValues(t, "EventName", *opts.lookupAttribute()[0].AttributeKey)
+
+	opts.Attributes = map[string]string{"ResourceName": "trailblazer", "ResourceType": "AWS::IAM::Role"}
+	assert.True(t, opts.match(e))
+	opts.Attributes["EventSource"] = "sts.amazonaws.com"
+	assert.False(t, opts.match(e))
+
+	opts = Options{StartTime: e.Time.Add(time.Minute)}
+	assert.False(t, opts.match(e))
+	assert.False(t, opts.filtered())
+}
@@ -0,0 +1,161 @@
+package findings
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+//Severity of a finding
+type Severity int
+
+//Severities, from least to most severe
+const (
+	SeverityInfo Severity = iota
+	SeverityLow
+	SeverityMedium
+	SeverityHigh
+	SeverityCritical
+)
+
+var severityNames = []string{"info", "low", "medium", "high", "critical"}
+
+//String returns the severity name
+func (s Severity) String() string {
+	if s < 0 || int(s) >= len(severityNames) {
+		return fmt.Sprintf("severity(%d)", int(s))
+	}
+	return severityNames[s]
+}
+
+//ParseSeverity returns the severity with the given name
+func ParseSeverity(name string) (Severity, error) {
+	for i, n := range severityNames {
+		if strings.EqualFold(n, name) {
+			return Severity(i), nil
+		}
+	}
+	return SeverityInfo, fmt.Errorf("Unknown severity '%v', expected one of %v", name, strings.Join(severityNames, ", "))
+}
+
+//MarshalJSON writes the severity as its name
+func (s Severity) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+//UnmarshalJSON reads a severity name
+func (s *Severity) UnmarshalJSON(data []byte) error {
+	var name string
+	err := json.Unmarshal(data, &name)
+	if err != nil {
+		return err
+	}
+	*s, err = ParseSeverity(name)
+	return err
+}
+
+//Status is the triage state of a finding
+type Status string
+
+//Finding statuses
+const (
+	StatusOpen          Status = "open"
+	StatusAcknowledged  Status = "acknowledged"
+	StatusFalsePositive Status = "false-positive"
+)
+
+//ParseStatus returns the status with the given name
+func ParseStatus(name string) (Status, error) {
+	for _, s := range []Status{StatusOpen, StatusAcknowledged, StatusFalsePositive} {
+		if string(s) == strings.ToLower(name) {
+			return s, nil
+		}
+	}
+	return StatusOpen, fmt.Errorf("Unknown status '%v', expected one of %v, %v, %v", name, StatusOpen, StatusAcknowledged, StatusFalsePositive)
+}
+
+//Finding is an issue reported by an analyzer
+type Finding struct {
+	//ID identifies the finding, it is derived from the rule, principal, session and title
+	ID string `json:"id"`
+	//RuleID identifies the rule that produced the finding
+	RuleID string `json:"ruleId"`
+	//Title is a one line description
+	Title    string   `json:"title"`
+	Severity Severity `json:"severity"`
+	//Principal is the ARN of the identity the finding is about
+	Principal string `json:"principal"`
+	//SessionARN is the assumed role session ARN, if any
+	SessionARN string `json:"sessionArn,omitempty"`
+	//EventIDs lists the events that triggered the finding
+	EventIDs  []string  `json:"eventIds"`
+	FirstSeen time.Time `json:"firstSeen"`
+	LastSeen  time.Time `json:"lastSeen"`
+	//Evidence holds free form details, such as the source IP or user agent
+	Evidence map[string]string `json:"evidence,omitempty"`
+	//Status is set by the user, it is kept when the finding is reported again
+	Status Status `json:"status"`
+}
+
+//Key returns the ID findings get, findings with the same key are merged
+func (f *Finding) Key() string {
+	sum := sha1.Sum([]byte(strings.Join([]string{f.RuleID, f.Principal, f.SessionARN, f.Title}, "\n")))
+	return hex.EncodeToString(sum[:8])
+}
+
+//merge adds the events and time range of other to f
+func (f *Finding) merge(other Finding) {
+	known := make(map[string]bool)
+	for _, id := range f.EventIDs {
+		known[id] = true
+	}
+	for _, id := range other.EventIDs {
+		if !known[id] {
+			f.EventIDs = append(f.EventIDs, id)
+		}
+	}
+	if f.FirstSeen.IsZero() || (!other.FirstSeen.IsZero() && other.FirstSeen.Before(f.FirstSeen)) {
+		f.FirstSeen = other.FirstSeen
+	}
+	if other.LastSeen.After(f.LastSeen) {
+		f.LastSeen = other.LastSeen
+	}
+	if other.Severity > f.Severity {
+		f.Severity = other.Severity
+	}
+	for k, v := range other.Evidence {
+		if f.Evidence == nil {
+			f.Evidence = make(map[string]string)
+		}
+		if _, ok := f.Evidence[k]; !ok {
+			f.Evidence[k] = v
+		}
+	}
+}
+
+//Reporter receives findings from analyzers
+type Reporter interface {
+	Report(f Finding)
+}
+
+// BySeverity sorts findings, most severe first, then by last seen time, newest first
+type BySeverity []Finding
+
+func (a BySeverity) Len() int      { return len(a) }
+func (a BySeverity) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a BySeverity) Less(i, j int) bool {
+	if a[i].Severity != a[j].Severity {
+		return a[i].Severity > a[j].Severity
+	}
+	return a[i].LastSeen.After(a[j].LastSeen)
+}
+
+// ByTime sorts findings by first seen time
+type ByTime []Finding
+
+func (a ByTime) Len() int           { return len(a) }
+func (a ByTime) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a ByTime) Less(i, j int) bool { return a[i].FirstSeen.Before(a[j].FirstSeen) }
@@ -0,0 +1,101 @@
+package findings
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSeverity(t *testing.T) {
+	s, err := ParseSeverity("High")
+	assert.NoError(t, err)
+	assert.EqualValues(t, SeverityHigh, s)
+	_, err = ParseSeverity("bogus")
+	assert.Error(t, err)
+
+	data, err := json.Marshal(SeverityCritical)
+	assert.NoError(t, err)
+	assert.EqualValues(t, `"critical"`, string(data))
+	assert.NoError(t, json.Unmarshal([]byte(`"low"`), &s))
+	assert.EqualValues(t, SeverityLow, s)
+}
+
+func TestList_Report(t *testing.T) {
+	t1 := time.Date(2018, 10, 29, 11, 0, 0, 0, time.UTC)
+	t2 := t1.Add(time.Hour)
+	l := NewList("")
+	l.Report(Finding{RuleID: "r1", Title: "a", Severity: SeverityLow, Principal: "p", EventIDs: []string{"1"}, FirstSeen: t2})
+	l.Report(Finding{RuleID: "r1", Title: "a", Severity: SeverityLow, Principal: "p", EventIDs: []string{"2", "1"}, FirstSeen: t1})
+	l.Report(Finding{RuleID: "r2", Title: "b", Severity: SeverityHigh, Principal: "q", EventIDs: []string{"3"}, FirstSeen: t1})
+	assert.EqualValues(t, 2, l.Len())
+
+	list := l.All()
+	assert.EqualValues(t, "r2", list[0].RuleID)
+	merged := list[1]
+	assert.EqualValues(t, []string{"1", "2"}, merged.EventIDs)
+	assert.EqualValues(t, t1, merged.FirstSeen)
+	assert.EqualValues(t, t2, merged.LastSeen)
+
+	found, ok := l.Get(merged.ID)
+	assert.True(t, ok)
+	assert.EqualValues(t, merged, found)
+
+	assert.Len(t, l.Find(Filter{MinSeverity: SeverityMedium}), 1)
+	assert.Len(t, l.Find(Filter{Principal: "p"}), 1)
+	assert.Len(t, l.Find(Filter{RuleID: "r3"}), 0)
+}
+
+func TestList_SetStatus(t *testing.T) {
+	l := NewList("")
+	f := Finding{RuleID: "r1", Title: "a", Severity: SeverityHigh}
+	l.Report(f)
+	id := f.Key()
+	assert.Len(t, l.Find(Filter{Open: true}), 1)
+	assert.NoError(t, l.SetStatus(id, StatusFalsePositive))
+	assert.Error(t, l.SetStatus("missing", StatusAcknowledged))
+	assert.Len(t, l.Find(Filter{Open: true}), 0)
+
+	l.Clear()
+	l.Report(f)
+	found, ok := l.Get(id)
+	assert.True(t, ok)
+	assert.EqualValues(t, StatusFalsePositive, found.Status)
+}
+
+func TestList_SaveStatuses(t *testing.T) {
+	dir, err := ioutil.TempDir("", "korra-findings")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "findings.json")
+
+	l := NewList(path)
+	f := Finding{RuleID: "r1", Title: "a", Severity: SeverityHigh}
+	l.Report(f)
+	l.Report(Finding{RuleID: "r2", Title: "b"})
+	assert.NoError(t, l.SetStatus(f.Key(), StatusAcknowledged))
+	l.Clear()
+	assert.NoError(t, l.Save())
+
+	//the status of a cleared finding is kept when it is reported after a load
+	loaded := NewList(path)
+	assert.NoError(t, loaded.Load())
+	assert.EqualValues(t, 0, loaded.Len())
+	loaded.Report(f)
+	found, ok := loaded.Get(f.Key())
+	assert.True(t, ok)
+	assert.EqualValues(t, StatusAcknowledged, found.Status)
+
+	//files holding only the findings are loaded
+	data, err := json.Marshal([]Finding{f})
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(path, data, 0644))
+	loaded = NewList(path)
+	assert.NoError(t, loaded.Load())
+	assert.EqualValues(t, 1, loaded.Len())
+}
@@ -0,0 +1,194 @@
+package findings
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+)
+
+//Filter selects findings, empty fields match everything
+type Filter struct {
+	MinSeverity Severity
+	RuleID      string
+	Principal   string
+	SessionARN  string
+	//Open selects only findings that were not acknowledged or marked as false positives
+	Open bool
+}
+
+//Match returns true if f passes the filter
+func (fl *Filter) Match(f Finding) bool {
+	if f.Severity < fl.MinSeverity {
+		return false
+	}
+	if fl.RuleID != "" && fl.RuleID != f.RuleID {
+		return false
+	}
+	if fl.Principal != "" && fl.Principal != f.Principal {
+		return false
+	}
+	if fl.SessionARN != "" && fl.SessionARN != f.SessionARN {
+		return false
+	}
+	if fl.Open && f.Status != StatusOpen {
+		return false
+	}
+	return true
+}
+
+//List holds findings by ID, it is safe for concurrent use
+type List struct {
+	mutex    sync.RWMutex
+	findings map[string]*Finding
+	statuses map[string]Status
+	path     string
+}
+
+//NewList creates an empty list persisted to the file at path
+func NewList(path string) *List {
+	return &List{
+		findings: make(map[string]*Finding),
+		statuses: make(map[string]Status),
+		path:     path,
+	}
+}
+
+//Report adds a finding, merging it with an existing finding with the same key
+func (l *List) Report(f Finding) {
+	f.ID = f.Key()
+	if f.LastSeen.Before(f.FirstSeen) {
+		f.LastSeen = f.FirstSeen
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	existing, ok := l.findings[f.ID]
+	if ok {
+		existing.merge(f)
+		return
+	}
+	if f.Status != "" && f.Status != StatusOpen {
+		l.statuses[f.ID] = f.Status
+	}
+	f.Status = l.statuses[f.ID]
+	if f.Status == "" {
+		f.Status = StatusOpen
+	}
+	l.findings[f.ID] = &f
+}
+
+//SetStatus sets the status of a finding, the status is kept if the finding is cleared and reported again
+func (l *List) SetStatus(id string, status Status) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	f, ok := l.findings[id]
+	if !ok {
+		return fmt.Errorf("Finding '%v' not found", id)
+	}
+	f.Status = status
+	if status == StatusOpen {
+		delete(l.statuses, id)
+	} else {
+		l.statuses[id] = status
+	}
+	return nil
+}
+
+//Get returns the finding with the given ID
+func (l *List) Get(id string) (Finding, bool) {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	f, ok := l.findings[id]
+	if !ok {
+		return Finding{}, false
+	}
+	return *f, true
+}
+
+//Find returns the findings matching filter, most severe first
+func (l *List) Find(filter Filter) []Finding {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	list := make([]Finding, 0)
+	for _, f := range l.findings {
+		if filter.Match(*f) {
+			list = append(list, *f)
+		}
+	}
+	sort.Sort(BySeverity(list))
+	return list
+}
+
+//All returns all findings, most severe first
+func (l *List) All() []Finding {
+	return l.Find(Filter{})
+}
+
+//Len returns the number of findings
+func (l *List) Len() int {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	return len(l.findings)
+}
+
+//Clear removes all findings, statuses set by SetStatus are kept
+func (l *List) Clear() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.findings = make(map[string]*Finding)
+}
+
+//listFile is the content of the file of a list
+type listFile struct {
+	Findings []Finding `json:"findings"`
+	//Statuses are the statuses set by SetStatus, of cleared findings too
+	Statuses map[string]Status `json:"statuses,omitempty"`
+}
+
+//Load loads findings and their statuses from file
+func (l *List) Load() error {
+	_, err := os.Stat(l.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	data, err := ioutil.ReadFile(l.path)
+	if err != nil {
+		return err
+	}
+	var file listFile
+	err = json.Unmarshal(data, &file)
+	if err != nil {
+		//older files hold only the findings
+		if json.Unmarshal(data, &file.Findings) != nil {
+			return err
+		}
+	}
+	l.mutex.Lock()
+	l.findings = make(map[string]*Finding)
+	l.statuses = make(map[string]Status)
+	for id, status := range file.Statuses {
+		l.statuses[id] = status
+	}
+	l.mutex.Unlock()
+	for _, f := range file.Findings {
+		l.Report(f)
+	}
+	return nil
+}
+
+//Save persists all findings and statuses to a local file
+func (l *List) Save() error {
+	file := listFile{Findings: l.All(), Statuses: make(map[string]Status)}
+	l.mutex.RLock()
+	for id, status := range l.statuses {
+		file.Statuses[id] = status
+	}
+	l.mutex.RUnlock()
+	data, err := json.Marshal(file)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(l.path, data, 0644)
+}
@@ -0,0 +1,353 @@
+package analyzer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dtylman/korra/analyzer/findings"
+)
+
+//RuleHunt is reported for every run of a hunt with new hits
+const RuleHunt = "hunt"
+
+//maxHuntRuns is the number of runs kept in the history of a hunt
+const maxHuntRuns = 30
+
+//searchPageSize is the number of hits read at once when all hits of a search are read
+const searchPageSize = 500
+
+//SavedSearch is a named search, a hunt is a saved search that runs after every load and
+//reports a finding with the new hits of each run
+type SavedSearch struct {
+	Name  string `json:"name"`
+	Query string `json:"query"`
+	//Window if set limits hits to events of the last window before the search runs, e.g. "24h" or "7d"
+	Window string `json:"window,omitempty"`
+	//Start and End limit hits to a fixed time range, Window takes precedence over Start
+	Start time.Time `json:"start,omitempty"`
+	End   time.Time `json:"end,omitempty"`
+	//Filters are the facet terms the hits must have, see SearchOptions.Refine
+	Filters map[string]string `json:"filters,omitempty"`
+	Sort    string            `json:"sort,omitempty"`
+	//Hunt runs the search after every load
+	Hunt     bool              `json:"hunt"`
+	Severity findings.Severity `json:"severity"`
+	//Runs is the history of the hunt, newest last
+	Runs []HuntRun `json:"runs,omitempty"`
+	//Reports are the findings the hunt reported, kept when the findings are cleared so a hit is
+	//new only once and the findings are reported again without searching
+	Reports []HuntReport `json:"reports,omitempty"`
+}
+
+//HuntReport is the finding of a hunt run, with the hits no earlier run reported
+type HuntReport struct {
+	Time     time.Time `json:"time"`
+	EventIDs []string  `json:"eventIds"`
+}
+
+//HuntRun is the result of a single run of a hunt
+type HuntRun struct {
+	Time time.Time `json:"time"`
+	//Hits is the number of events matching the hunt
+	Hits uint64 `json:"hits"`
+	//New is the number of hits that were not reported before
+	New   int    `json:"new"`
+	Error string `json:"error,omitempty"`
+}
+
+//ParseWindow parses a relative time window, a Go duration or a number of days (e.g. "7d")
+func ParseWindow(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	if strings.HasSuffix(value, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(value, "d"))
+		if err == nil && days > 0 {
+			return time.Duration(days) * 24 * time.Hour, nil
+		}
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("Invalid time window '%v', expected a duration such as 12h or 7d", value)
+	}
+	return d, nil
+}
+
+//Options returns the search options of the saved search, a window is relative to now
+func (s SavedSearch) Options(now time.Time) (SearchOptions, error) {
+	opts := SearchOptions{Query: s.Query, Sort: s.Sort, Start: s.Start, End: s.End, Filters: s.Filters}
+	window, err := ParseWindow(s.Window)
+	if err != nil {
+		return opts, err
+	}
+	if window > 0 {
+		opts.Start = now.Add(-window)
+	}
+	return opts, nil
+}
+
+//LastRun returns the latest run of the hunt
+func (s SavedSearch) LastRun() (HuntRun, bool) {
+	if len(s.Runs) == 0 {
+		return HuntRun{}, false
+	}
+	return s.Runs[len(s.Runs)-1], true
+}
+
+//SavedSearches holds saved searches by name, it is safe for concurrent use
+type SavedSearches struct {
+	mutex sync.RWMutex
+	items map[string]SavedSearch
+	path  string
+}
+
+//NewSavedSearches creates an empty list persisted to the file at path
+func NewSavedSearches(path string) *SavedSearches {
+	return &SavedSearches{
+		items: make(map[string]SavedSearch),
+		path:  path,
+	}
+}
+
+//Put adds or replaces the saved search with the same name, the run history and reports are kept
+func (ss *SavedSearches) Put(s SavedSearch) error {
+	if s.Name == "" {
+		return errors.New("Saved search has no name")
+	}
+	opts, err := s.Options(time.Now())
+	if err != nil {
+		return err
+	}
+	_, err = opts.Request()
+	if err != nil {
+		return err
+	}
+	ss.mutex.Lock()
+	defer ss.mutex.Unlock()
+	s.Runs = ss.items[s.Name].Runs
+	s.Reports = ss.items[s.Name].Reports
+	ss.items[s.Name] = s
+	return nil
+}
+
+//Get returns the saved search with the given name
+func (ss *SavedSearches) Get(name string) (SavedSearch, bool) {
+	ss.mutex.RLock()
+	defer ss.mutex.RUnlock()
+	s, ok := ss.items[name]
+	return s, ok
+}
+
+//Delete removes the saved search with the given name
+func (ss *SavedSearches) Delete(name string) error {
+	ss.mutex.Lock()
+	defer ss.mutex.Unlock()
+	_, ok := ss.items[name]
+	if !ok {
+		return fmt.Errorf("Saved search '%v' not found", name)
+	}
+	delete(ss.items, name)
+	return nil
+}
+
+//List returns the saved searches by name
+func (ss *SavedSearches) List() []SavedSearch {
+	ss.mutex.RLock()
+	defer ss.mutex.RUnlock()
+	list := make([]SavedSearch, 0)
+	for _, s := range ss.items {
+		list = append(list, s)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+//addRun adds a run to the history of a hunt and its report, if it has new hits, to the reports
+func (ss *SavedSearches) addRun(name string, run HuntRun, report *HuntReport) {
+	ss.mutex.Lock()
+	defer ss.mutex.Unlock()
+	s, ok := ss.items[name]
+	if !ok {
+		return
+	}
+	s.Runs = append(s.Runs, run)
+	if report != nil {
+		s.Reports = append(s.Reports, *report)
+	}
+	if len(s.Runs) > maxHuntRuns {
+		s.Runs = s.Runs[len(s.Runs)-maxHuntRuns:]
+	}
+	ss.items[name] = s
+}
+
+//Load loads saved searches from file
+func (ss *SavedSearches) Load() error {
+	_, err := os.Stat(ss.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	data, err := ioutil.ReadFile(ss.path)
+	if err != nil {
+		return err
+	}
+	var list []SavedSearch
+	err = json.Unmarshal(data, &list)
+	if err != nil {
+		return err
+	}
+	ss.mutex.Lock()
+	defer ss.mutex.Unlock()
+	ss.items = make(map[string]SavedSearch)
+	for _, s := range list {
+		ss.items[s.Name] = s
+	}
+	return nil
+}
+
+//Save persists all saved searches to a local file
+func (ss *SavedSearches) Save() error {
+	data, err := json.Marshal(ss.List())
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(ss.path, data, 0644)
+}
+
+//RunHunts runs every hunt on the search index and reports a finding with the hits that were not
+//reported by an earlier run, the findings of earlier runs are reported again if they were cleared
+func (e *Engine) RunHunts(ctx context.Context) error {
+	e.running.Lock()
+	defer e.running.Unlock()
+	return e.runHunts(ctx)
+}
+
+func (e *Engine) runHunts(ctx context.Context) error {
+	if e.Indexer == nil {
+		return nil
+	}
+	for _, s := range e.Searches.List() {
+		if !s.Hunt {
+			continue
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		e.reportHunt(s)
+		run, report, err := e.hunt(ctx, s)
+		if err != nil {
+			log.Printf("Hunt '%v': %v", s.Name, err)
+			run.Error = err.Error()
+		} else {
+			log.Printf("Hunt '%v': %v hits, %v new", s.Name, run.Hits, run.New)
+		}
+		if report != nil {
+			e.Report(e.huntFinding(s, *report))
+		}
+		e.Searches.addRun(s.Name, run, report)
+	}
+	return ctx.Err()
+}
+
+//reportHunt reports the findings of the earlier runs of a hunt that are not in the findings,
+//as after a full analysis clears them
+func (e *Engine) reportHunt(s SavedSearch) {
+	for _, report := range s.Reports {
+		f := newHuntFinding(s, report)
+		if _, ok := e.Findings.Get(f.Key()); !ok {
+			e.Report(e.huntFinding(s, report))
+		}
+	}
+}
+
+//hunt runs a single hunt, reading the IDs of all of its hits a page at a time, and returns the
+//report of the hits not reported before, nil if there are none
+func (e *Engine) hunt(ctx context.Context, s SavedSearch) (HuntRun, *HuntReport, error) {
+	run := HuntRun{Time: time.Now()}
+	opts, err := s.Options(run.Time)
+	if err != nil {
+		return run, nil, err
+	}
+	known := make(map[string]bool)
+	for _, report := range s.Reports {
+		for _, id := range report.EventIDs {
+			known[id] = true
+		}
+	}
+	report := &HuntReport{Time: run.Time, EventIDs: make([]string, 0)}
+	opts.Size = searchPageSize
+	opts.Sort = SortOldest
+	for {
+		if ctx.Err() != nil {
+			return run, nil, ctx.Err()
+		}
+		req, err := opts.Request()
+		if err != nil {
+			return run, nil, err
+		}
+		//only the IDs of the hits are needed
+		req.Fields = nil
+		sr, err := e.Indexer.Search(req)
+		if err != nil {
+			return run, nil, err
+		}
+		run.Hits = sr.Total
+		for _, hit := range sr.Hits {
+			if !known[hit.ID] {
+				known[hit.ID] = true
+				report.EventIDs = append(report.EventIDs, hit.ID)
+			}
+		}
+		if len(sr.Hits) < opts.Size {
+			break
+		}
+		opts = opts.Next()
+	}
+	run.New = len(report.EventIDs)
+	if run.New == 0 {
+		return run, nil, nil
+	}
+	return run, report, nil
+}
+
+//newHuntFinding returns the finding of a hunt report without its time range
+func newHuntFinding(s SavedSearch, report HuntReport) findings.Finding {
+	return findings.Finding{
+		RuleID: RuleHunt,
+		Title: fmt.Sprintf("Hunt '%v' matched %v new events at %v", s.Name, len(report.EventIDs),
+			report.Time.UTC().Format(time.RFC3339)),
+		Severity: s.Severity,
+		EventIDs: report.EventIDs,
+		Evidence: map[string]string{
+			"hunt":  s.Name,
+			"query": s.Query,
+		},
+	}
+}
+
+//huntFinding returns the finding of a hunt report, the time range is of its events in the store
+func (e *Engine) huntFinding(s SavedSearch, report HuntReport) findings.Finding {
+	f := newHuntFinding(s, report)
+	for _, id := range report.EventIDs {
+		event, ok := e.Events.Get(id)
+		if !ok {
+			continue
+		}
+		if f.FirstSeen.IsZero() || event.Time.Before(f.FirstSeen) {
+			f.FirstSeen = event.Time
+		}
+		if event.Time.After(f.LastSeen) {
+			f.LastSeen = event.Time
+		}
+	}
+	return f
+}
@@ -0,0 +1,121 @@
+package analyzer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dtylman/korra/analyzer/cloudtrail"
+	"github.com/dtylman/korra/analyzer/findings"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseWindow(t *testing.T) {
+	d, err := ParseWindow("7d")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 7*24*time.Hour, d)
+	d, err = ParseWindow("90m")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 90*time.Minute, d)
+	_, err = ParseWindow("-1h")
+	assert.Error(t, err)
+	_, err = ParseWindow("week")
+	assert.Error(t, err)
+}
+
+func TestEngine_RunHunts(t *testing.T) {
+	e, cleanup := newSearchEngine(t)
+	defer cleanup()
+
+	assert.Error(t, e.Searches.Put(SavedSearch{Query: "eventName:AssumeRole"}))
+	assert.Error(t, e.Searches.Put(SavedSearch{Name: "bad", Window: "week"}))
+	assert.NoError(t, e.Searches.Put(SavedSearch{Name: "roles", Query: "eventName:AssumeRole", Hunt: true, Severity: findings.SeverityHigh}))
+	assert.NoError(t, e.Searches.Put(SavedSearch{Name: "recent", Query: "eventName:AssumeRole", Window: "24h", Hunt: true}))
+	assert.NoError(t, e.Searches.Put(SavedSearch{Name: "saved", Query: "eventName:GetObject"}))
+
+	assert.NoError(t, e.RunHunts(context.Background()))
+	found := e.Findings.Find(findings.Filter{RuleID: RuleHunt, MinSeverity: findings.SeverityHigh})
+	assert.Len(t, found, 1)
+	assert.Len(t, found[0].EventIDs, 5)
+	assert.False(t, found[0].FirstSeen.IsZero())
+	s, _ := e.Searches.Get("roles")
+	run, ok := s.LastRun()
+	assert.True(t, ok)
+	assert.EqualValues(t, 5, run.Hits)
+	assert.EqualValues(t, 5, run.New)
+	s, _ = e.Searches.Get("recent")
+	run, _ = s.LastRun()
+	assert.EqualValues(t, 0, run.Hits)
+	assert.Empty(t, s.Reports)
+	s, _ = e.Searches.Get("saved")
+	assert.Empty(t, s.Runs)
+
+	//only hits of new events are new in the next run
+	e.Events.AddEvent(cloudtrail.Event{ID: "new", Name: "AssumeRole", Time: time.Now()})
+	assert.NoError(t, e.Reindex(context.Background(), nil))
+	assert.NoError(t, e.RunHunts(context.Background()))
+	s, _ = e.Searches.Get("roles")
+	assert.Len(t, s.Runs, 2)
+	run, _ = s.LastRun()
+	assert.EqualValues(t, 6, run.Hits)
+	assert.EqualValues(t, 1, run.New)
+	s, _ = e.Searches.Get("recent")
+	run, _ = s.LastRun()
+	assert.EqualValues(t, 1, run.New)
+	//a finding for each run with new hits
+	found = e.Findings.Find(findings.Filter{RuleID: RuleHunt})
+	assert.Len(t, found, 3)
+	for _, f := range found {
+		if len(f.EventIDs) == 1 {
+			assert.EqualValues(t, []string{"new"}, f.EventIDs)
+		}
+	}
+
+	//run history is kept when a search is saved again
+	assert.NoError(t, e.Searches.Put(SavedSearch{Name: "roles", Query: "eventName:AssumeRole"}))
+	s, _ = e.Searches.Get("roles")
+	assert.Len(t, s.Runs, 2)
+	assert.NoError(t, e.Save())
+	saved := NewSavedSearches(e.Searches.path)
+	assert.NoError(t, saved.Load())
+	assert.Len(t, saved.List(), 3)
+}
+
+func TestEngine_AnalyzeHuntsTwice(t *testing.T) {
+	e, cleanup := newSearchEngine(t)
+	defer cleanup()
+
+	assert.NoError(t, e.Searches.Put(SavedSearch{Name: "roles", Query: "eventName:AssumeRole", Hunt: true}))
+	assert.NoError(t, e.Analyze(context.Background(), nil))
+	s, _ := e.Searches.Get("roles")
+	run, _ := s.LastRun()
+	assert.EqualValues(t, 5, run.Hits)
+	assert.EqualValues(t, 5, run.New)
+	assert.Len(t, s.Reports, 1)
+	before := e.Findings.Find(findings.Filter{RuleID: RuleHunt})
+	assert.Len(t, before, 1)
+	assert.NoError(t, e.Findings.SetStatus(before[0].ID, findings.StatusAcknowledged))
+
+	//findings are cleared by the analysis and reported again, hits already reported are not new
+	assert.NoError(t, e.Analyze(context.Background(), nil))
+	s, _ = e.Searches.Get("roles")
+	run, _ = s.LastRun()
+	assert.EqualValues(t, 5, run.Hits)
+	assert.EqualValues(t, 0, run.New)
+	assert.Len(t, s.Reports, 1)
+	after := e.Findings.Find(findings.Filter{RuleID: RuleHunt})
+	assert.Len(t, after, 1)
+	assert.EqualValues(t, before[0].ID, after[0].ID)
+	assert.EqualValues(t, before[0].EventIDs, after[0].EventIDs)
+	assert.EqualValues(t, before[0].FirstSeen, after[0].FirstSeen)
+	assert.EqualValues(t, findings.StatusAcknowledged, after[0].Status)
+
+	//reports are kept when the search is saved again and persisted
+	assert.NoError(t, e.Searches.Put(SavedSearch{Name: "roles", Query: "eventName:AssumeRole", Hunt: true}))
+	assert.NoError(t, e.Save())
+	saved := NewSavedSearches(e.Searches.path)
+	assert.NoError(t, saved.Load())
+	s, _ = saved.Get("roles")
+	assert.Len(t, s.Reports, 1)
+	assert.Len(t, s.Reports[0].EventIDs, 5)
+}
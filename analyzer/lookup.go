@@ -0,0 +1,106 @@
+package analyzer
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudtrail"
+	"github.com/aws/aws-sdk-go/service/cloudtrail/cloudtrailiface"
+)
+
+//DefaultRequestsPerSecond is the LookupEvents limit of CloudTrail in an account and region
+const DefaultRequestsPerSecond = 2
+
+//maxRetries is the number of times a throttled or failed LookupEvents request is retried
+const maxRetries = 8
+
+//retryBackoff is the wait before the first retry, doubled on every retry up to maxBackoff
+var (
+	retryBackoff = time.Second
+	maxBackoff   = 30 * time.Second
+)
+
+//lookupPager pages through LookupEvents results within a requests per second budget,
+//backing off and retrying when throttled
+type lookupPager struct {
+	svc      cloudtrailiface.CloudTrailAPI
+	input    *cloudtrail.LookupEventsInput
+	interval time.Duration
+	last     time.Time
+	done     bool
+}
+
+func newLookupPager(svc cloudtrailiface.CloudTrailAPI, input *cloudtrail.LookupEventsInput, requestsPerSecond float64) *lookupPager {
+	if requestsPerSecond <= 0 {
+		requestsPerSecond = DefaultRequestsPerSecond
+	}
+	return &lookupPager{
+		svc:      svc,
+		input:    input,
+		interval: time.Duration(float64(time.Second) / requestsPerSecond),
+	}
+}
+
+//more returns true if there are more pages to read
+func (p *lookupPager) more() bool {
+	return !p.done
+}
+
+//token returns the token of the next page, empty for the first page
+func (p *lookupPager) token() string {
+	return aws.StringValue(p.input.NextToken)
+}
+
+//wait blocks until the next request fits in the budget or ctx is done
+func (p *lookupPager) wait(ctx context.Context) error {
+	err := sleep(ctx, time.Until(p.last.Add(p.interval)))
+	p.last = time.Now()
+	return err
+}
+
+//sleep pauses for d or until ctx is done
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+//next reads the next page, the last page is the one without a next token
+func (p *lookupPager) next(ctx context.Context) ([]*cloudtrail.Event, error) {
+	backoff := retryBackoff
+	for retry := 0; ; retry++ {
+		err := p.wait(ctx)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := p.svc.LookupEventsWithContext(ctx, p.input)
+		if err == nil {
+			p.input.NextToken = resp.NextToken
+			p.done = p.token() == ""
+			return resp.Events, nil
+		}
+		if retry >= maxRetries || !(request.IsErrorThrottle(err) || request.IsErrorRetryable(err)) {
+			return nil, err
+		}
+		log.Printf("%v, retrying in %v", err, backoff)
+		err = sleep(ctx, backoff)
+		if err != nil {
+			return nil, err
+		}
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
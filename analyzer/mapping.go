@@ -0,0 +1,95 @@
+package analyzer
+
+import (
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/analysis/analyzer/custom"
+	"github.com/blevesearch/bleve/analysis/analyzer/standard"
+	"github.com/blevesearch/bleve/analysis/token/lowercase"
+	"github.com/blevesearch/bleve/analysis/tokenizer/single"
+	"github.com/blevesearch/bleve/mapping"
+)
+
+//boolFields are the boolean fields of an event
+var boolFields = []string{"readOnly", "managementEvent"}
+
+//keywordAnalyzer indexes a field as a single case insensitive term
+const keywordAnalyzer = "keyword_lowercase"
+
+func keywordField() *mapping.FieldMapping {
+	fm := bleve.NewTextFieldMapping()
+	fm.Analyzer = keywordAnalyzer
+	return fm
+}
+
+//documentMapping maps the keyword fields of a document, sub documents of the fields
+//are given by their path (e.g. "userIdentity.arn")
+func documentMapping(keywords ...string) *mapping.DocumentMapping {
+	dm := bleve.NewDocumentMapping()
+	for _, name := range keywords {
+		dm.AddFieldMappingsAt(name, keywordField())
+	}
+	return dm
+}
+
+//newIndexMapping returns the mapping of CloudTrail events: identifiers (ARNs, IP addresses,
+//access keys, event names, error codes...) are keywords, eventTime is a datetime and the
+//raw JSON is stored but not indexed
+func newIndexMapping() (mapping.IndexMapping, error) {
+	im := bleve.NewIndexMapping()
+	err := im.AddCustomAnalyzer(keywordAnalyzer, map[string]interface{}{
+		"type":          custom.Name,
+		"tokenizer":     single.Name,
+		"token_filters": []string{lowercase.Name},
+	})
+	if err != nil {
+		return nil, err
+	}
+	im.DefaultAnalyzer = standard.Name
+
+	event := documentMapping("eventSource", "errorCode", "eventName", "sourceIPAddress", "awsRegion",
+		"requestID", "eventID", "eventType", "recipientAccountId", "eventVersion", "eventCategory", "sharedEventID",
+		"vpcEndpointId", "sessionCredentialFromConsole", "apiVersion")
+	event.AddFieldMappingsAt("eventTime", bleve.NewDateTimeFieldMapping())
+	for _, field := range boolFields {
+		event.AddFieldMappingsAt(field, bleve.NewBooleanFieldMapping())
+	}
+	event.AddFieldMappingsAt("userAgent", bleve.NewTextFieldMapping())
+	raw := bleve.NewTextFieldMapping()
+	raw.Index = false
+	raw.IncludeInAll = false
+	raw.IncludeTermVectors = false
+	event.AddFieldMappingsAt("raw", raw)
+
+	userIdentity := documentMapping("type", "principalId", "arn", "accountId", "userName", "accessKeyId",
+		"invokedBy", "identityProvider")
+	sessionContext := documentMapping("sourceIdentity")
+	attributes := documentMapping("mfaAuthenticated")
+	attributes.AddFieldMappingsAt("creationDate", bleve.NewDateTimeFieldMapping())
+	sessionContext.AddSubDocumentMapping("attributes", attributes)
+	sessionContext.AddSubDocumentMapping("sessionIssuer", documentMapping("type", "principalId", "arn", "accountId", "userName"))
+	sessionContext.AddSubDocumentMapping("webIdFederationData", documentMapping("federatedProvider"))
+	userIdentity.AddSubDocumentMapping("sessionContext", sessionContext)
+	event.AddSubDocumentMapping("userIdentity", userIdentity)
+	event.AddSubDocumentMapping("requestParameters", documentMapping("roleArn", "roleSessionName"))
+	credentials := documentMapping("accessKeyId")
+	credentials.AddFieldMappingsAt("sessionToken", disabledField())
+	responseElements := bleve.NewDocumentMapping()
+	responseElements.AddSubDocumentMapping("credentials", credentials)
+	responseElements.AddSubDocumentMapping("assumedRoleUser", documentMapping("assumedRoleId", "arn"))
+	event.AddSubDocumentMapping("responseElements", responseElements)
+	event.AddSubDocumentMapping("resources", documentMapping("ARN", "accountId", "type"))
+	event.AddSubDocumentMapping("tlsDetails", documentMapping("tlsVersion", "cipherSuite", "clientProvidedHostHeader"))
+
+	im.DefaultMapping = event
+	return im, nil
+}
+
+//disabledField is neither indexed nor stored
+func disabledField() *mapping.FieldMapping {
+	fm := bleve.NewTextFieldMapping()
+	fm.Index = false
+	fm.Store = false
+	fm.IncludeInAll = false
+	fm.IncludeTermVectors = false
+	return fm
+}
@@ -0,0 +1,215 @@
+package analyzer
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	cloudtrailevents "github.com/dtylman/korra/analyzer/cloudtrail"
+)
+
+//pipelineBuffer is the number of events queued for an analyzer before the pipeline waits for it
+const pipelineBuffer = 256
+
+//ParallelAnalyzer is an analyzer that does not need events in time order and is safe for
+//concurrent use, events are delivered to it by several workers. Other analyzers get events
+//in time order on a single worker.
+type ParallelAnalyzer interface {
+	Analyzer
+	//Workers returns the number of workers to run the analyzer on
+	Workers() int
+}
+
+//Flusher is an analyzer that buffers events, Flush is called after the last event of every run
+type Flusher interface {
+	Flush() error
+}
+
+//AnalyzerStats holds the throughput and latency of an analyzer in the last run
+type AnalyzerStats struct {
+	Name    string `json:"name"`
+	Workers int    `json:"workers"`
+	Events  int    `json:"events"`
+	Errors  int    `json:"errors"`
+	//Busy is the total time spent in Analyze, by all workers
+	Busy time.Duration `json:"busy"`
+	//Elapsed is the time from the start of the run until the analyzer finished its last event
+	Elapsed time.Duration `json:"elapsed"`
+	//MaxLatency is the longest time spent on a single event
+	MaxLatency time.Duration `json:"maxLatency"`
+}
+
+//Throughput returns the number of events analyzed per second
+func (s AnalyzerStats) Throughput() float64 {
+	if s.Elapsed <= 0 {
+		return 0
+	}
+	return float64(s.Events) / s.Elapsed.Seconds()
+}
+
+//MeanLatency returns the average time spent on a single event
+func (s AnalyzerStats) MeanLatency() time.Duration {
+	if s.Events == 0 {
+		return 0
+	}
+	return s.Busy / time.Duration(s.Events)
+}
+
+//Stats returns the analyzer stats of the last run
+func (e *Engine) Stats() []AnalyzerStats {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+	list := make([]AnalyzerStats, len(e.stats))
+	copy(list, e.stats)
+	return list
+}
+
+//stage runs one analyzer of the pipeline on its workers
+type stage struct {
+	analyzer Analyzer
+	in       chan cloudtrailevents.Event
+	mutex    sync.Mutex
+	stats    AnalyzerStats
+}
+
+func newStage(a Analyzer) *stage {
+	workers := 1
+	if pa, ok := a.(ParallelAnalyzer); ok && pa.Workers() > 1 {
+		workers = pa.Workers()
+	}
+	return &stage{
+		analyzer: a,
+		in:       make(chan cloudtrailevents.Event, pipelineBuffer),
+		stats:    AnalyzerStats{Name: a.Name(), Workers: workers},
+	}
+}
+
+//start starts the stage workers, wg is done when all of them stopped
+func (s *stage) start(ctx context.Context, start time.Time, wg *sync.WaitGroup) {
+	for i := 0; i < s.stats.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for event := range s.in {
+				if ctx.Err() != nil {
+					continue
+				}
+				began := time.Now()
+				err := s.analyzer.Analyze(ctx, event)
+				if err != nil {
+					log.Printf("%v: %v", s.analyzer.Name(), err)
+				}
+				s.record(start, time.Since(began), err)
+			}
+		}()
+	}
+}
+
+//flush flushes the analyzer if it buffers events, the time is accounted to the analyzer
+func (s *stage) flush(start time.Time) {
+	f, ok := s.analyzer.(Flusher)
+	if !ok {
+		return
+	}
+	began := time.Now()
+	err := f.Flush()
+	if err != nil {
+		log.Printf("%v: %v", s.analyzer.Name(), err)
+		s.stats.Errors++
+	}
+	s.stats.Busy += time.Since(began)
+	s.stats.Elapsed = time.Since(start)
+}
+
+func (s *stage) record(start time.Time, latency time.Duration, err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.stats.Events++
+	if err != nil {
+		s.stats.Errors++
+	}
+	s.stats.Busy += latency
+	if latency > s.stats.MaxLatency {
+		s.stats.MaxLatency = latency
+	}
+	s.stats.Elapsed = time.Since(start)
+}
+
+//eventSource is a sequence of events in time order, the event store or events in memory
+type eventSource interface {
+	Len() int
+	Each(fn func(e cloudtrailevents.Event) error) error
+}
+
+//eventSlice is an eventSource of events in memory
+type eventSlice []cloudtrailevents.Event
+
+func (es eventSlice) Len() int {
+	return len(es)
+}
+
+func (es eventSlice) Each(fn func(e cloudtrailevents.Event) error) error {
+	for _, e := range es {
+		err := fn(e)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//run runs all analyzers on events in a pipeline, every analyzer on its own workers.
+//Stops when ctx is done.
+func (e *Engine) run(ctx context.Context, events eventSource, progress ProgressFunc) error {
+	return e.runAnalyzers(ctx, e.Analyzers(), events, progress)
+}
+
+//runAnalyzers runs analyzers on events in a pipeline, every analyzer on its own workers
+func (e *Engine) runAnalyzers(ctx context.Context, analyzers []Analyzer, events eventSource, progress ProgressFunc) error {
+	log.Println("Indexing...")
+	start := time.Now()
+	var wg sync.WaitGroup
+	stages := make([]*stage, 0)
+	for _, a := range analyzers {
+		s := newStage(a)
+		s.start(ctx, start, &wg)
+		stages = append(stages, s)
+	}
+	total := events.Len()
+	i := 0
+	err := events.Each(func(event cloudtrailevents.Event) error {
+		if ctx.Err() != nil {
+			log.Printf("Analysis stopped after %v of %v events", i, total)
+			return ctx.Err()
+		}
+		if progress != nil {
+			progress(i, total)
+		}
+		for _, s := range stages {
+			select {
+			case s.in <- event:
+			case <-ctx.Done():
+			}
+		}
+		i++
+		return nil
+	})
+	for _, s := range stages {
+		close(s.in)
+	}
+	wg.Wait()
+
+	stats := make([]AnalyzerStats, len(stages))
+	for i, s := range stages {
+		s.flush(start)
+		stats[i] = s.stats
+	}
+	e.mutex.Lock()
+	e.stats = stats
+	e.mutex.Unlock()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
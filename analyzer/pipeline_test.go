@@ -0,0 +1,69 @@
+package analyzer
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/dtylman/korra/analyzer/cloudtrail"
+	"github.com/stretchr/testify/assert"
+)
+
+//recordingAnalyzer records the IDs of the events it analyzed
+type recordingAnalyzer struct {
+	mutex   sync.Mutex
+	ids     []string
+	workers int
+}
+
+func (ra *recordingAnalyzer) Analyze(ctx context.Context, event cloudtrail.Event) error {
+	ra.mutex.Lock()
+	defer ra.mutex.Unlock()
+	ra.ids = append(ra.ids, event.ID)
+	return nil
+}
+
+func (ra *recordingAnalyzer) Name() string {
+	return fmt.Sprintf("recording-%v", ra.workers)
+}
+
+func (ra *recordingAnalyzer) Clear() error {
+	return nil
+}
+
+//parallelAnalyzer is a recordingAnalyzer run on several workers
+type parallelAnalyzer struct {
+	recordingAnalyzer
+}
+
+func (pa *parallelAnalyzer) Workers() int {
+	return pa.workers
+}
+
+func TestEngine_Pipeline(t *testing.T) {
+	e, _, cleanup := newTestEngine(t, "")
+	defer cleanup()
+	ordered := &recordingAnalyzer{workers: 1}
+	parallel := &parallelAnalyzer{recordingAnalyzer{workers: 4}}
+	e.AddAnalyzer(ordered)
+	e.AddAnalyzer(parallel)
+
+	events := make([]cloudtrail.Event, 0)
+	ids := make([]string, 0)
+	for i := 0; i < 1000; i++ {
+		events = append(events, cloudtrail.Event{ID: fmt.Sprintf("%v", i)})
+		ids = append(ids, fmt.Sprintf("%v", i))
+	}
+	assert.NoError(t, e.run(context.Background(), eventSlice(events), nil))
+	assert.EqualValues(t, ids, ordered.ids)
+	assert.ElementsMatch(t, ids, parallel.ids)
+
+	stats := e.Stats()
+	if assert.Len(t, stats, 3) {
+		assert.EqualValues(t, "recording-1", stats[1].Name)
+		assert.EqualValues(t, 1000, stats[1].Events)
+		assert.EqualValues(t, 4, stats[2].Workers)
+		assert.EqualValues(t, 1000, stats[2].Events)
+	}
+}
@@ -0,0 +1,92 @@
+package analyzer
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+)
+
+//IndexDrift describes how the search index differs from the stored events
+type IndexDrift struct {
+	//Events is the number of stored events
+	Events int `json:"events"`
+	//Indexed is the number of indexed events
+	Indexed uint64 `json:"indexed"`
+	//NewestMissing is set if the newest stored event is not indexed
+	NewestMissing bool `json:"newestMissing"`
+}
+
+//Drifted returns true if the index does not match the stored events
+func (d IndexDrift) Drifted() bool {
+	return d.Indexed != uint64(d.Events) || d.NewestMissing
+}
+
+func (d IndexDrift) String() string {
+	if d.NewestMissing {
+		return fmt.Sprintf("%v events stored, %v indexed, newest event not indexed", d.Events, d.Indexed)
+	}
+	return fmt.Sprintf("%v events stored, %v indexed", d.Events, d.Indexed)
+}
+
+//IndexDrift compares the search index to the stored events
+func (e *Engine) IndexDrift() (IndexDrift, error) {
+	var d IndexDrift
+	if e.Indexer == nil {
+		return d, fmt.Errorf("Engine was created without a search index")
+	}
+	d.Events = e.Events.Len()
+	var err error
+	d.Indexed, err = e.Indexer.Count()
+	if err != nil {
+		return d, err
+	}
+	newest, ok := e.Events.Newest()
+	if ok {
+		doc, err := e.Indexer.Document(newest.ID)
+		if err != nil {
+			return d, err
+		}
+		d.NewestMissing = doc == nil
+	}
+	return d, nil
+}
+
+//Reindex rebuilds the search index from the stored events, without changing sessions or findings
+func (e *Engine) Reindex(ctx context.Context, progress ProgressFunc) error {
+	e.running.Lock()
+	defer e.running.Unlock()
+	if e.Indexer == nil {
+		return fmt.Errorf("Engine was created without a search index")
+	}
+	log.Println("Rebuilding the search index...")
+	err := e.Indexer.Clear()
+	if err != nil {
+		return err
+	}
+	err = e.runAnalyzers(ctx, []Analyzer{e.Indexer}, e.Events, progress)
+	if err == context.Canceled {
+		log.Println("Cancelled, the search index is incomplete")
+	}
+	return err
+}
+
+//openIndex opens the search index at path, an index that cannot be opened is moved aside
+//to path.broken and replaced with an empty one to be rebuilt by Reindex
+func openIndex(path string) (*BleveAnalyzer, error) {
+	ba, err := NewBleveAnalyzer(path)
+	if err == nil {
+		return ba, nil
+	}
+	log.Printf("Cannot open the search index %v: %v, creating a new one", path, err)
+	broken := path + ".broken"
+	err = os.RemoveAll(broken)
+	if err != nil {
+		return nil, err
+	}
+	err = os.Rename(path, broken)
+	if err != nil {
+		return nil, err
+	}
+	return NewBleveAnalyzer(path)
+}
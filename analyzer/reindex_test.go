@@ -0,0 +1,50 @@
+package analyzer
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dtylman/korra/analyzer/cloudtrail"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEngine_Reindex(t *testing.T) {
+	e, dir, cleanup := newTestEngine(t, "korra.db")
+	defer cleanup()
+	indexPath := filepath.Join(dir, "korra.db")
+	start := time.Date(2018, 10, 30, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 10; i++ {
+		e.Events.AddEvent(cloudtrail.Event{ID: fmt.Sprintf("%v", i), Time: start.Add(time.Duration(i) * time.Minute)})
+	}
+	d, err := e.IndexDrift()
+	assert.NoError(t, err)
+	assert.True(t, d.Drifted())
+	assert.True(t, d.NewestMissing)
+
+	assert.NoError(t, e.Reindex(context.Background(), nil))
+	d, err = e.IndexDrift()
+	assert.NoError(t, err)
+	assert.False(t, d.Drifted(), d.String())
+	assert.EqualValues(t, 10, d.Indexed)
+	assert.NoError(t, e.Save())
+	assert.NoError(t, e.Close())
+
+	//a broken index is moved aside and replaced with an empty one
+	assert.NoError(t, os.RemoveAll(indexPath))
+	assert.NoError(t, ioutil.WriteFile(indexPath, []byte("broken"), 0644))
+	e, err = NewEngine(filepath.Join(dir, "events.db"), indexPath)
+	require.NoError(t, err)
+	defer e.Close()
+	d, err = e.IndexDrift()
+	assert.NoError(t, err)
+	assert.EqualValues(t, 0, d.Indexed)
+	assert.True(t, d.Drifted())
+	_, err = os.Stat(indexPath + ".broken")
+	assert.NoError(t, err)
+}
@@ -0,0 +1,200 @@
+package analyzer
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	cloudtrailevents "github.com/dtylman/korra/analyzer/cloudtrail"
+)
+
+//loadFromS3 reads the trail log files from the options bucket, newer than the account and region checkpoints
+func (e *Engine) loadFromS3(ctx context.Context, b *batch, progress ProgressFunc) error {
+	opts := e.Options()
+	sess, err := opts.NewSession()
+	if err != nil {
+		return err
+	}
+	return e.readS3(ctx, s3.New(sess), &opts, b, progress)
+}
+
+//since returns the time loading an account and region should start from
+func (e *Engine) since(opts *Options, account, region string) time.Time {
+	cp, ok := e.Checkpoints.Get(account, region)
+	if ok && cp.Time.After(opts.StartTime) {
+		return cp.Time
+	}
+	return opts.StartTime
+}
+
+//readS3 reads the log files of the options bucket, events read before ctx is cancelled are kept.
+//The checkpoints are not advanced if a log file cannot be read, so the next load reads it again.
+func (e *Engine) readS3(ctx context.Context, svc s3iface.S3API, opts *Options, b *batch, progress ProgressFunc) error {
+	log.Printf("Listing s3://%v/%v...", opts.Bucket, opts.Prefix)
+	keys, err := s3LogKeys(ctx, svc, opts, func(account, region string) time.Time {
+		return e.since(opts, account, region)
+	})
+	if err != nil {
+		return err
+	}
+	log.Printf("Found %v log files", len(keys))
+	failed := 0
+	var failure error
+	truncated := false
+	for i, key := range keys {
+		if progress != nil {
+			progress(i, len(keys))
+		}
+		if opts.MaxOnlineEvents > 0 && len(b.events) >= opts.MaxOnlineEvents {
+			log.Printf("Stopped after %v events, checkpoint is not advanced", len(b.events))
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		events, err := s3ReadLog(ctx, svc, opts.Bucket, key)
+		if err != nil {
+			log.Printf("%v: %v", key, err)
+			if failed == 0 {
+				failure = fmt.Errorf("%v: %v", key, err)
+			}
+			failed++
+			continue
+		}
+		for _, event := range events {
+			if opts.MaxOnlineEvents > 0 && len(b.events) >= opts.MaxOnlineEvents {
+				truncated = true
+				break
+			}
+			if opts.match(event) {
+				b.add(event)
+			}
+		}
+		log.Printf("Read %v new events", len(b.events))
+	}
+	if progress != nil {
+		progress(len(keys), len(keys))
+	}
+	if failed > 0 {
+		return fmt.Errorf("Cannot read %v of %v log files, checkpoint is not advanced, load again to retry: %v", failed, len(keys), failure)
+	}
+	if truncated {
+		log.Printf("Stopped after %v events, checkpoint is not advanced", len(b.events))
+		return nil
+	}
+	b.commit(opts)
+	return nil
+}
+
+//s3LogKeys lists the keys of all log files matching the account, region and time filters,
+//since returns the start time of every account and region
+func s3LogKeys(ctx context.Context, svc s3iface.S3API, opts *Options, since func(account, region string) time.Time) ([]string, error) {
+	base := path.Join(opts.Prefix, "AWSLogs") + "/"
+	accounts := opts.Accounts
+	if len(accounts) == 0 {
+		var err error
+		accounts, err = s3SubFolders(ctx, svc, opts.Bucket, base)
+		if err != nil {
+			return nil, err
+		}
+	}
+	keys := make([]string, 0)
+	for _, account := range accounts {
+		trail := base + account + "/CloudTrail/"
+		regions := opts.Regions
+		if len(regions) == 0 || opts.allRegions() {
+			var err error
+			regions, err = s3SubFolders(ctx, svc, opts.Bucket, trail)
+			if err != nil {
+				return nil, err
+			}
+		}
+		for _, region := range regions {
+			for _, prefix := range s3DayPrefixes(trail+region+"/", since(account, region), opts.EndTime) {
+				err := svc.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+					Bucket: aws.String(opts.Bucket),
+					Prefix: aws.String(prefix),
+				}, func(page *s3.ListObjectsV2Output, last bool) bool {
+					for _, object := range page.Contents {
+						key := aws.StringValue(object.Key)
+						if strings.HasSuffix(key, ".json") || strings.HasSuffix(key, ".json.gz") {
+							keys = append(keys, key)
+						}
+					}
+					return true
+				})
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return keys, nil
+}
+
+//s3SubFolders returns the names of the "folders" directly under prefix
+func s3SubFolders(ctx context.Context, svc s3iface.S3API, bucket string, prefix string) ([]string, error) {
+	folders := make([]string, 0)
+	err := svc.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket:    aws.String(bucket),
+		Prefix:    aws.String(prefix),
+		Delimiter: aws.String("/"),
+	}, func(page *s3.ListObjectsV2Output, last bool) bool {
+		for _, cp := range page.CommonPrefixes {
+			name := strings.TrimSuffix(strings.TrimPrefix(aws.StringValue(cp.Prefix), prefix), "/")
+			if name != "" {
+				folders = append(folders, name)
+			}
+		}
+		return true
+	})
+	return folders, err
+}
+
+//s3DayPrefixes returns a YYYY/MM/DD prefix for every day between start and end (now if zero),
+//or the region prefix itself if there is no start
+func s3DayPrefixes(region string, start, end time.Time) []string {
+	if start.IsZero() {
+		return []string{region}
+	}
+	if end.IsZero() {
+		end = time.Now()
+	}
+	prefixes := make([]string, 0)
+	day := start.UTC().Truncate(24 * time.Hour)
+	for !day.After(end.UTC()) {
+		prefixes = append(prefixes, region+day.Format("2006/01/02")+"/")
+		day = day.AddDate(0, 0, 1)
+	}
+	return prefixes
+}
+
+//s3ReadLog downloads and decodes a single (possibly gzipped) log file
+func s3ReadLog(ctx context.Context, svc s3iface.S3API, bucket string, key string) ([]cloudtrailevents.Event, error) {
+	out, err := svc.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer out.Body.Close()
+	var r io.Reader = out.Body
+	if strings.HasSuffix(key, ".gz") {
+		gz, err := gzip.NewReader(out.Body)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+	return cloudtrailevents.ReadLog(r)
+}
@@ -0,0 +1,181 @@
+package analyzer
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/stretchr/testify/assert"
+)
+
+//fakeS3 is an in memory bucket
+type fakeS3 struct {
+	s3iface.S3API
+	objects map[string][]byte
+}
+
+func (f *fakeS3) ListObjectsV2PagesWithContext(ctx aws.Context, in *s3.ListObjectsV2Input, fn func(*s3.ListObjectsV2Output, bool) bool, opts ...request.Option) error {
+	out := new(s3.ListObjectsV2Output)
+	prefix := aws.StringValue(in.Prefix)
+	delimiter := aws.StringValue(in.Delimiter)
+	seen := make(map[string]bool)
+	keys := make([]string, 0)
+	for key := range f.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		rest := strings.TrimPrefix(key, prefix)
+		if delimiter != "" && strings.Contains(rest, delimiter) {
+			cp := prefix + rest[:strings.Index(rest, delimiter)+1]
+			if !seen[cp] {
+				seen[cp] = true
+				out.CommonPrefixes = append(out.CommonPrefixes, &s3.CommonPrefix{Prefix: aws.String(cp)})
+			}
+			continue
+		}
+		out.Contents = append(out.Contents, &s3.Object{Key: aws.String(key)})
+	}
+	fn(out, true)
+	return nil
+}
+
+func (f *fakeS3) GetObjectWithContext(ctx aws.Context, in *s3.GetObjectInput, opts ...request.Option) (*s3.GetObjectOutput, error) {
+	data := f.objects[aws.StringValue(in.Key)]
+	return &s3.GetObjectOutput{Body: ioutil.NopCloser(bytes.NewReader(data))}, nil
+}
+
+func gzipped(t *testing.T, data string) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	_, err := gz.Write([]byte(data))
+	assert.NoError(t, err)
+	assert.NoError(t, gz.Close())
+	return buf.Bytes()
+}
+
+func TestS3DayPrefixes(t *testing.T) {
+	region := "AWSLogs/123/CloudTrail/us-east-1/"
+	assert.EqualValues(t, []string{region}, s3DayPrefixes(region, time.Time{}, time.Now()))
+	assert.Len(t, s3DayPrefixes(region, time.Now().Add(-24*time.Hour), time.Time{}), 2)
+	start := time.Date(2018, 10, 30, 22, 0, 0, 0, time.UTC)
+	end := time.Date(2018, 11, 1, 1, 0, 0, 0, time.UTC)
+	assert.EqualValues(t, []string{
+		region + "2018/10/30/",
+		region + "2018/10/31/",
+		region + "2018/11/01/",
+	}, s3DayPrefixes(region, start, end))
+}
+
+func TestLoadFromS3(t *testing.T) {
+	svc := &fakeS3{objects: map[string][]byte{
+		"trail/AWSLogs/123/CloudTrail/us-east-1/2018/10/30/a.json.gz": gzipped(t, `{"Records":[{"eventID":"1","eventName":"AssumeRole","eventTime":"2018-10-30T10:00:00Z"}]}`),
+		"trail/AWSLogs/123/CloudTrail/eu-west-1/2018/10/31/b.json":    []byte(`{"Records":[{"eventID":"2","eventTime":"2018-10-31T10:00:00Z"}]}`),
+		"trail/AWSLogs/456/CloudTrail/us-east-1/2018/10/31/c.json.gz": gzipped(t, `{"Records":[{"eventID":"3","eventTime":"2018-10-31T11:00:00Z","recipientAccountId":"456","awsRegion":"us-east-1"}]}`),
+		"trail/AWSLogs/123/CloudTrail-Digest/us-east-1/digest.txt":    []byte("ignored"),
+	}}
+	e, _, cleanup := newTestEngine(t, "")
+	defer cleanup()
+	opts := Options{Bucket: "bucket", Prefix: "trail"}
+
+	assert.NoError(t, e.readS3(context.Background(), svc, &opts, e.newBatch(), nil))
+	assert.EqualValues(t, 3, e.Events.Len())
+	assert.Contains(t, e.Events.Events()[0].RawEvent, `"eventID":"`)
+	cp, ok := e.Checkpoints.Get("456", "us-east-1")
+	assert.True(t, ok)
+	assert.EqualValues(t, "3", cp.EventID)
+
+	e.Clear()
+	opts.Accounts = []string{"123"}
+	assert.NoError(t, e.readS3(context.Background(), svc, &opts, e.newBatch(), nil))
+	assert.EqualValues(t, 2, e.Events.Len())
+
+	//a cancelled read keeps nothing new and does not fail silently
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	e.Clear()
+	assert.Equal(t, context.Canceled, e.readS3(ctx, svc, &opts, e.newBatch(), nil))
+	assert.EqualValues(t, 0, e.Events.Len())
+	assert.NoError(t, e.readS3(context.Background(), svc, &opts, e.newBatch(), nil))
+
+	//reading again adds nothing
+	b := e.newBatch()
+	assert.NoError(t, e.readS3(context.Background(), svc, &opts, b, nil))
+	assert.Len(t, b.events, 0)
+	assert.EqualValues(t, 2, e.Events.Len())
+
+	//a log file that cannot be read fails the load and keeps the checkpoint so it is read again
+	e.Clear()
+	opts.Accounts = []string{"456"}
+	bad := "trail/AWSLogs/456/CloudTrail/us-east-1/2018/10/31/bad.json.gz"
+	svc.objects[bad] = []byte("not gzipped")
+	assert.Error(t, e.readS3(context.Background(), svc, &opts, e.newBatch(), nil))
+	assert.EqualValues(t, 1, e.Events.Len())
+	_, ok = e.Checkpoints.Get("456", "us-east-1")
+	assert.False(t, ok)
+	delete(svc.objects, bad)
+	assert.NoError(t, e.readS3(context.Background(), svc, &opts, e.newBatch(), nil))
+	cp, ok = e.Checkpoints.Get("456", "us-east-1")
+	assert.True(t, ok)
+	assert.EqualValues(t, "3", cp.EventID)
+}
+
+func TestLoadFromS3_StartAfterCheckpoint(t *testing.T) {
+	svc := &fakeS3{objects: map[string][]byte{
+		"trail/AWSLogs/456/CloudTrail/us-east-1/2018/10/31/c.json.gz": gzipped(t, `{"Records":[{"eventID":"3","eventTime":"2018-10-31T11:00:00Z","recipientAccountId":"456","awsRegion":"us-east-1"}]}`),
+	}}
+	e, _, cleanup := newTestEngine(t, "")
+	defer cleanup()
+	e.Checkpoints.Update("456", "us-east-1", Checkpoint{Time: time.Date(2018, 10, 29, 0, 0, 0, 0, time.UTC), EventID: "0"})
+
+	//events between the checkpoint and the start time are not read, the checkpoint stays
+	opts := Options{Bucket: "bucket", Prefix: "trail", StartTime: time.Date(2018, 10, 31, 0, 0, 0, 0, time.UTC)}
+	assert.True(t, e.partial(&opts, "456", "us-east-1"))
+	assert.False(t, e.partial(&opts, "456", "eu-west-1"))
+	assert.NoError(t, e.readS3(context.Background(), svc, &opts, e.newBatch(), nil))
+	assert.EqualValues(t, 1, e.Events.Len())
+	cp, _ := e.Checkpoints.Get("456", "us-east-1")
+	assert.EqualValues(t, "0", cp.EventID)
+
+	opts.StartTime = time.Time{}
+	assert.NoError(t, e.readS3(context.Background(), svc, &opts, e.newBatch(), nil))
+	cp, _ = e.Checkpoints.Get("456", "us-east-1")
+	assert.EqualValues(t, "3", cp.EventID)
+}
+
+func TestLoadFromS3_LimitInsideLastFile(t *testing.T) {
+	svc := &fakeS3{objects: map[string][]byte{
+		"trail/AWSLogs/456/CloudTrail/us-east-1/2018/10/31/c.json.gz": gzipped(t, `{"Records":[
+			{"eventID":"1","eventTime":"2018-10-31T10:00:00Z","recipientAccountId":"456","awsRegion":"us-east-1"},
+			{"eventID":"2","eventTime":"2018-10-31T11:00:00Z","recipientAccountId":"456","awsRegion":"us-east-1"},
+			{"eventID":"3","eventTime":"2018-10-31T12:00:00Z","recipientAccountId":"456","awsRegion":"us-east-1"}]}`),
+	}}
+	e, _, cleanup := newTestEngine(t, "")
+	defer cleanup()
+
+	//the events after the limit are read by the next load
+	opts := Options{Bucket: "bucket", Prefix: "trail", MaxOnlineEvents: 2}
+	assert.NoError(t, e.readS3(context.Background(), svc, &opts, e.newBatch(), nil))
+	assert.EqualValues(t, 2, e.Events.Len())
+	_, ok := e.Checkpoints.Get("456", "us-east-1")
+	assert.False(t, ok)
+
+	opts.MaxOnlineEvents = 0
+	assert.NoError(t, e.readS3(context.Background(), svc, &opts, e.newBatch(), nil))
+	assert.EqualValues(t, 3, e.Events.Len())
+	cp, ok := e.Checkpoints.Get("456", "us-east-1")
+	assert.True(t, ok)
+	assert.EqualValues(t, "3", cp.EventID)
+}
@@ -0,0 +1,150 @@
+package analyzer
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/search/query"
+)
+
+//sort orders of search results
+const (
+	SortByScore = "score"
+	SortNewest  = "newest"
+	SortOldest  = "oldest"
+)
+
+//SortOrders lists the supported sort orders
+var SortOrders = []string{SortByScore, SortNewest, SortOldest}
+
+//SearchFields are the stored fields returned with every hit
+var SearchFields = []string{timeField, "eventName", "userIdentity.arn", "sourceIPAddress"}
+
+const (
+	timeField     = "eventTime"
+	defaultSize   = 10
+	maxSearchSize = 1000
+)
+
+//SearchOptions describes a page of a search on the indexed events
+type SearchOptions struct {
+	//Query is a bleve query string, all events match an empty query
+	Query string
+	//From is the offset of the first hit
+	From int
+	//Size is the number of hits in a page
+	Size int
+	//Sort is one of SortOrders, SortByScore if empty
+	Sort string
+	//Start if set, only events since it match
+	Start time.Time
+	//End if set, only events until it match
+	End time.Time
+	//Filters maps fields to the term they must have, terms are indexed in lower case
+	Filters map[string]string
+}
+
+//MatchesAll returns true if the options have no query, time range or filters
+func (o SearchOptions) MatchesAll() bool {
+	return o.Query == "" && o.Start.IsZero() && o.End.IsZero() && len(o.Filters) == 0
+}
+
+//Next returns the options of the next page
+func (o SearchOptions) Next() SearchOptions {
+	o.From += o.size()
+	return o
+}
+
+//Prev returns the options of the previous page
+func (o SearchOptions) Prev() SearchOptions {
+	o.From -= o.size()
+	if o.From < 0 {
+		o.From = 0
+	}
+	return o
+}
+
+func (o SearchOptions) size() int {
+	if o.Size <= 0 {
+		return defaultSize
+	}
+	if o.Size > maxSearchSize {
+		return maxSearchSize
+	}
+	return o.Size
+}
+
+//Request returns the bleve search request of the options, the query string is combined
+//with the time range and the filters as a conjunction
+func (o SearchOptions) Request() (*bleve.SearchRequest, error) {
+	var q query.Query = bleve.NewMatchAllQuery()
+	if o.Query != "" {
+		q = bleve.NewQueryStringQuery(o.Query)
+	}
+	conjuncts := []query.Query{q}
+	if !o.Start.IsZero() || !o.End.IsZero() {
+		inclusive := true
+		timeRange := bleve.NewDateRangeInclusiveQuery(o.Start, o.End, &inclusive, &inclusive)
+		timeRange.SetField(timeField)
+		conjuncts = append(conjuncts, timeRange)
+	}
+	fields := make([]string, 0)
+	for field := range o.Filters {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	for _, field := range fields {
+		filter, err := filterQuery(field, o.Filters[field])
+		if err != nil {
+			return nil, err
+		}
+		conjuncts = append(conjuncts, filter)
+	}
+	if len(conjuncts) > 1 {
+		q = bleve.NewConjunctionQuery(conjuncts...)
+	}
+	req := bleve.NewSearchRequestOptions(q, o.size(), o.From, false)
+	req.Fields = SearchFields
+	switch o.Sort {
+	case "", SortByScore:
+		req.SortBy([]string{"-_score", "-" + timeField})
+	case SortNewest:
+		req.SortBy([]string{"-" + timeField, "_id"})
+	case SortOldest:
+		req.SortBy([]string{timeField, "_id"})
+	default:
+		return nil, fmt.Errorf("Unknown sort order '%v', expected one of %v", o.Sort, SortOrders)
+	}
+	return req, nil
+}
+
+//isBoolField returns true if field is one of boolFields
+func isBoolField(field string) bool {
+	for _, f := range boolFields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+//filterQuery returns the query of events where field has the term, terms of boolean fields
+//are "true" or "false"
+func filterQuery(field, term string) (query.Query, error) {
+	if isBoolField(field) {
+		value, err := strconv.ParseBool(term)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid filter '%v=%v', expected true or false", field, term)
+		}
+		q := bleve.NewBoolFieldQuery(value)
+		q.SetField(field)
+		return q, nil
+	}
+	q := bleve.NewTermQuery(strings.ToLower(term))
+	q.SetField(field)
+	return q, nil
+}
@@ -0,0 +1,101 @@
+package analyzer
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/dtylman/korra/analyzer/cloudtrail"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSearchOptions(t *testing.T) {
+	e, cleanup := newSearchEngine(t)
+	defer cleanup()
+	start := time.Date(2018, 10, 30, 0, 0, 0, 0, time.UTC)
+
+	search := func(opts SearchOptions) []string {
+		req, err := opts.Request()
+		assert.NoError(t, err)
+		sr, err := e.Indexer.Search(req)
+		assert.NoError(t, err)
+		ids := make([]string, 0)
+		for _, hit := range sr.Hits {
+			ids = append(ids, hit.ID)
+		}
+		return ids
+	}
+	opts := SearchOptions{Size: 10, Sort: SortOldest}
+	assert.EqualValues(t, []string{"00", "01", "02", "03", "04", "05", "06", "07", "08", "09"}, search(opts))
+	opts = opts.Next().Next()
+	assert.EqualValues(t, []string{"20", "21", "22", "23", "24"}, search(opts))
+	opts = opts.Prev()
+	assert.EqualValues(t, 10, opts.From)
+
+	opts = SearchOptions{Query: "eventName:AssumeRole", Sort: SortNewest}
+	assert.EqualValues(t, []string{"20", "15", "10", "05", "00"}, search(opts))
+	opts.Start = start.Add(5 * time.Hour)
+	opts.End = start.Add(15 * time.Hour)
+	assert.EqualValues(t, []string{"15", "10", "05"}, search(opts))
+
+	opts = SearchOptions{Sort: SortOldest, Filters: map[string]string{"readOnly": "false", "tlsDetails.tlsVersion": "TLSv1.3"}}
+	assert.EqualValues(t, []string{"05", "15"}, search(opts))
+
+	_, err := SearchOptions{Sort: "random"}.Request()
+	assert.Error(t, err)
+	_, err = SearchOptions{Filters: map[string]string{"readOnly": "maybe"}}.Request()
+	assert.Error(t, err)
+}
+
+//newSearchEngine returns an engine with 25 indexed events, an hour apart, every 5th is an AssumeRole
+//and the others are read only
+func newSearchEngine(t *testing.T) (*Engine, func()) {
+	e, _, cleanup := newTestEngine(t, "korra.db")
+	start := time.Date(2018, 10, 30, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 25; i++ {
+		name := "GetObject"
+		if i%5 == 0 {
+			name = "AssumeRole"
+		}
+		e.Events.AddEvent(cloudtrail.Event{ID: fmt.Sprintf("%02d", i), Name: name, SourceIPAddress: fmt.Sprintf("10.0.0.%v", i%2),
+			Time: start.Add(time.Duration(i) * time.Hour), ReadOnly: i%5 != 0,
+			TLSDetails: &cloudtrail.TLSDetails{TLSVersion: fmt.Sprintf("TLSv1.%v", 2+i%2)}})
+	}
+	require.NoError(t, e.Reindex(context.Background(), nil))
+	return e, cleanup
+}
+
+func TestBleveAnalyzer_AddFacets(t *testing.T) {
+	e, cleanup := newSearchEngine(t)
+	defer cleanup()
+
+	opts := SearchOptions{Query: "eventName:GetObject"}
+	req, err := opts.Request()
+	assert.NoError(t, err)
+	assert.NoError(t, e.Indexer.AddFacets(req, opts))
+	sr, err := e.Indexer.Search(req)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 20, sr.Total)
+	ips := sr.Facets["sourceIPAddress"].Terms
+	assert.Len(t, ips, 2)
+	assert.EqualValues(t, 10, ips[0].Count)
+	readOnly := Terms(sr, "readOnly")
+	assert.Len(t, readOnly, 1)
+	assert.EqualValues(t, "true", readOnly[0].Term)
+
+	buckets := Histogram(sr)
+	assert.Len(t, buckets, 25)
+	assert.EqualValues(t, 0, buckets[0].Count)
+	assert.EqualValues(t, 1, buckets[1].Count)
+	assert.EqualValues(t, time.Hour, buckets[1].End.Sub(buckets[1].Start))
+
+	opts = opts.Refine("sourceIPAddress", ips[0].Term)
+	req, err = opts.Request()
+	assert.NoError(t, err)
+	sr, err = e.Indexer.Search(req)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 10, sr.Total)
+	assert.Empty(t, opts.Unrefine("sourceIPAddress").Filters)
+}
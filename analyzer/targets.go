@@ -0,0 +1,208 @@
+package analyzer
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+//AllRegions in Options.Regions loads from every region enabled in the account
+const AllRegions = "all"
+
+//maxParallelTargets is the number of accounts and regions loaded concurrently
+const maxParallelTargets = 4
+
+//Target is an account and region events are loaded from
+type Target struct {
+	Account string `json:"account"`
+	Region  string `json:"region"`
+	//Loaded is the number of new events loaded from the target
+	Loaded int `json:"loaded"`
+	//Done is set when loading from the target ended
+	Done bool `json:"done"`
+	//Error is set if loading from the target failed
+	Error string `json:"error,omitempty"`
+}
+
+func (t Target) String() string {
+	if t.Region == "" {
+		return t.Account
+	}
+	return t.Account + "/" + t.Region
+}
+
+//Targets returns the accounts and regions of the last load and their status
+func (e *Engine) Targets() []Target {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+	list := make([]Target, len(e.targets))
+	copy(list, e.targets)
+	return list
+}
+
+func (e *Engine) setTargets(targets []Target) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.targets = make([]Target, len(targets))
+	copy(e.targets, targets)
+}
+
+func (e *Engine) setTarget(i int, t Target) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.targets[i] = t
+}
+
+//allRegions returns true if the options select every enabled region
+func (o *Options) allRegions() bool {
+	for _, region := range o.Regions {
+		if region == AllRegions {
+			return true
+		}
+	}
+	return false
+}
+
+//regions returns the regions to load from in the account of sess
+func (o *Options) regions(ctx context.Context, sess *session.Session) ([]string, error) {
+	if o.allRegions() {
+		return enabledRegions(ctx, sess)
+	}
+	if len(o.Regions) == 0 {
+		return []string{o.Region}, nil
+	}
+	return o.Regions, nil
+}
+
+//enabledRegions returns the regions enabled in the account of sess
+func enabledRegions(ctx context.Context, sess *session.Session) ([]string, error) {
+	out, err := ec2.New(sess).DescribeRegionsWithContext(ctx, &ec2.DescribeRegionsInput{})
+	if err != nil {
+		return nil, err
+	}
+	regions := make([]string, 0)
+	for _, r := range out.Regions {
+		regions = append(regions, aws.StringValue(r.RegionName))
+	}
+	sort.Strings(regions)
+	return regions, nil
+}
+
+//accountSession returns a session in account, assuming the audit role in accounts other than the caller's
+func accountSession(ctx context.Context, sess *session.Session, opts *Options, caller, account string) (*session.Session, error) {
+	if account == caller {
+		return sess, nil
+	}
+	if opts.AuditRole == "" {
+		return nil, fmt.Errorf("Cannot reach account %v, no audit role is set", account)
+	}
+	arn := fmt.Sprintf("arn:aws:iam::%v:role/%v", account, opts.AuditRole)
+	creds := stscreds.NewCredentials(sess, arn)
+	_, err := creds.Get()
+	if err != nil {
+		return nil, fmt.Errorf("Cannot assume %v: %v", arn, err)
+	}
+	return sess.Copy(&aws.Config{Credentials: creds}), nil
+}
+
+//plan returns the targets of a load and a session for each, targets that cannot be reached are
+//returned done with an error and a nil session
+func plan(ctx context.Context, sess *session.Session, opts *Options, caller string) ([]Target, []*session.Session) {
+	accounts := opts.Accounts
+	if len(accounts) == 0 {
+		accounts = []string{caller}
+	}
+	targets := make([]Target, 0)
+	sessions := make([]*session.Session, 0)
+	for _, account := range accounts {
+		accountSess, err := accountSession(ctx, sess, opts, caller, account)
+		if err == nil {
+			var regions []string
+			regions, err = opts.regions(ctx, accountSess)
+			for _, region := range regions {
+				targets = append(targets, Target{Account: account, Region: region})
+				sessions = append(sessions, accountSess.Copy(&aws.Config{Region: aws.String(region)}))
+			}
+		}
+		if err != nil {
+			log.Printf("%v: %v", account, err)
+			targets = append(targets, Target{Account: account, Done: true, Error: err.Error()})
+			sessions = append(sessions, nil)
+		}
+	}
+	return targets, sessions
+}
+
+//targetsError returns an error if loading failed in all targets
+func targetsError(targets []Target) error {
+	failed := 0
+	for _, t := range targets {
+		if t.Error != "" {
+			failed++
+		}
+	}
+	if failed == 0 {
+		return nil
+	}
+	if failed < len(targets) {
+		log.Printf("Loading failed in %v of %v accounts and regions", failed, len(targets))
+		return nil
+	}
+	if len(targets) == 1 {
+		return fmt.Errorf("%v: %v", targets[0], targets[0].Error)
+	}
+	return fmt.Errorf("Loading failed in all %v accounts and regions", len(targets))
+}
+
+//targetsProgress combines the progress of concurrently loaded targets
+type targetsProgress struct {
+	mutex    sync.Mutex
+	percents []int
+	progress ProgressFunc
+}
+
+func newTargetsProgress(count int, progress ProgressFunc) *targetsProgress {
+	return &targetsProgress{
+		percents: make([]int, count),
+		progress: progress,
+	}
+}
+
+//target returns the progress function of target i
+func (tp *targetsProgress) target(i int) ProgressFunc {
+	return func(value int, total int) {
+		if total <= 0 {
+			return
+		}
+		if value > total {
+			value = total
+		}
+		tp.set(i, 100*value/total)
+	}
+}
+
+//done marks target i as complete
+func (tp *targetsProgress) done(i int) {
+	tp.set(i, 100)
+}
+
+func (tp *targetsProgress) set(i int, percent int) {
+	if tp.progress == nil {
+		return
+	}
+	tp.mutex.Lock()
+	defer tp.mutex.Unlock()
+	tp.percents[i] = percent
+	sum := 0
+	for _, p := range tp.percents {
+		sum += p
+	}
+	tp.progress(sum, 100*len(tp.percents))
+}
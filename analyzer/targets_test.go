@@ -0,0 +1,119 @@
+package analyzer
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudtrail"
+	"github.com/aws/aws-sdk-go/service/cloudtrail/cloudtrailiface"
+	"github.com/stretchr/testify/assert"
+)
+
+//fakeCloudTrail returns its pages by next token, failing with the errors of a token first
+type fakeCloudTrail struct {
+	cloudtrailiface.CloudTrailAPI
+	pages  map[string]*cloudtrail.LookupEventsOutput
+	errors map[string][]error
+}
+
+func (f *fakeCloudTrail) LookupEventsWithContext(ctx aws.Context, in *cloudtrail.LookupEventsInput, opts ...request.Option) (*cloudtrail.LookupEventsOutput, error) {
+	token := aws.StringValue(in.NextToken)
+	if errs := f.errors[token]; len(errs) > 0 {
+		f.errors[token] = errs[1:]
+		return nil, errs[0]
+	}
+	page, ok := f.pages[token]
+	if !ok {
+		return nil, errors.New("invalid token")
+	}
+	return page, nil
+}
+
+func lookupEvent(data string) *cloudtrail.Event {
+	return &cloudtrail.Event{CloudTrailEvent: aws.String(data)}
+}
+
+func newFakeCloudTrail() *fakeCloudTrail {
+	return &fakeCloudTrail{
+		pages: map[string]*cloudtrail.LookupEventsOutput{
+			"": {
+				NextToken: aws.String("next"),
+				Events: []*cloudtrail.Event{
+					lookupEvent(`{"eventID":"1","eventTime":"2018-10-30T11:00:00Z"}`),
+					lookupEvent(`{"eventID":"2","eventTime":"2018-10-30T10:00:00Z","recipientAccountId":"456","awsRegion":"eu-west-1"}`),
+				},
+			},
+			"next": {
+				Events: []*cloudtrail.Event{
+					lookupEvent(`{"eventID":"3","eventTime":"2018-10-30T09:00:00Z"}`),
+				},
+			},
+		},
+		errors: make(map[string][]error),
+	}
+}
+
+func TestLoadTarget(t *testing.T) {
+	backoff := retryBackoff
+	retryBackoff = time.Millisecond
+	defer func() { retryBackoff = backoff }()
+	e, _, cleanup := newTestEngine(t, "")
+	defer cleanup()
+	svc := newFakeCloudTrail()
+	svc.errors["next"] = []error{awserr.New("ThrottlingException", "Rate exceeded", nil)}
+	opts := Options{MaxOnlineEvents: 10, RequestsPerSecond: 1000}
+	target := Target{Account: "123", Region: "us-east-1"}
+	assert.NoError(t, e.loadTarget(context.Background(), svc, &opts, &target, e.newBatch(), func(int, int) {}))
+	assert.EqualValues(t, 3, target.Loaded)
+	event, ok := e.Events.Get("1")
+	assert.True(t, ok)
+	assert.EqualValues(t, "123", event.RecipientAccountID)
+	assert.EqualValues(t, "us-east-1", event.Region)
+	event, _ = e.Events.Get("2")
+	assert.EqualValues(t, "456", event.RecipientAccountID)
+	cp, _ := e.Checkpoints.Get("123", "us-east-1")
+	assert.EqualValues(t, "1", cp.EventID)
+}
+
+func TestLoadTarget_Resume(t *testing.T) {
+	e, _, cleanup := newTestEngine(t, "")
+	defer cleanup()
+	svc := newFakeCloudTrail()
+	svc.errors["next"] = []error{awserr.New("AccessDeniedException", "denied", nil)}
+	opts := Options{MaxOnlineEvents: 10, RequestsPerSecond: 1000}
+	target := Target{Account: "123", Region: "us-east-1"}
+	assert.Error(t, e.loadTarget(context.Background(), svc, &opts, &target, e.newBatch(), func(int, int) {}))
+	assert.EqualValues(t, 2, e.Events.Len())
+	cp, _ := e.Checkpoints.Get("123", "us-east-1")
+	if assert.NotNil(t, cp.Resume) {
+		assert.EqualValues(t, "next", cp.Resume.NextToken)
+		assert.EqualValues(t, "1", cp.Resume.Newest.EventID)
+	}
+	assert.True(t, cp.Time.IsZero())
+
+	target = Target{Account: "123", Region: "us-east-1"}
+	assert.NoError(t, e.loadTarget(context.Background(), svc, &opts, &target, e.newBatch(), func(int, int) {}))
+	assert.EqualValues(t, 1, target.Loaded)
+	assert.EqualValues(t, 3, e.Events.Len())
+	cp, _ = e.Checkpoints.Get("123", "us-east-1")
+	assert.Nil(t, cp.Resume)
+	assert.EqualValues(t, "1", cp.EventID)
+}
+
+func TestTargetsProgress(t *testing.T) {
+	value, total := 0, 0
+	tp := newTargetsProgress(2, func(v int, t int) { value, total = v, t })
+	tp.target(0)(5, 10)
+	assert.EqualValues(t, 50, value)
+	assert.EqualValues(t, 200, total)
+	tp.done(1)
+	assert.EqualValues(t, 150, value)
+
+	assert.NoError(t, targetsError([]Target{{Account: "1"}, {Account: "2", Error: "denied"}}))
+	assert.Error(t, targetsError([]Target{{Account: "2", Error: "denied"}}))
+}
@@ -1,20 +1,24 @@
 package main
 
 import (
+	"context"
 	"encoding/base64"
 	"fmt"
-	"io/ioutil"
+	"html/template"
 	"log"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/blevesearch/bleve"
 	"github.com/blevesearch/bleve/analysis/char/html"
+	"github.com/blevesearch/bleve/search"
 	"github.com/dtylman/gowd"
 	"github.com/dtylman/gowd/bootstrap"
 	"github.com/dtylman/korra/analyzer"
 	"github.com/dtylman/korra/analyzer/assumerole"
-	"github.com/dtylman/korra/analyzer/cloudtrail"
+	"github.com/dtylman/korra/analyzer/findings"
 )
 
 type app struct {
@@ -24,9 +28,14 @@ type app struct {
 	loadPage       *gowd.Element
 	sessionsPage   *gowd.Element
 	errorsPage     *gowd.Element
+	findingsPage   *gowd.Element
 	searchPage     *gowd.Element
 	assumerolePage *gowd.Element
-	indexer        *analyzer.BleveAnalyzer
+	engine         *analyzer.Engine
+	search         analyzer.SearchOptions
+	//busy is set while a load, import or reindex runs on the engine, the case cannot be switched
+	busy      bool
+	busyMutex sync.Mutex
 }
 
 func newApp() (*app, error) {
@@ -47,6 +56,10 @@ func newApp() (*app, error) {
 	if err != nil {
 		return nil, err
 	}
+	a.findingsPage, err = a.loadFromTemplate("findings.html")
+	if err != nil {
+		return nil, err
+	}
 	a.assumerolePage, err = a.loadFromTemplate("assumerole.html")
 	if err != nil {
 		return nil, err
@@ -62,19 +75,53 @@ func newApp() (*app, error) {
 	a.content = a.em["main-content"]
 
 	a.em["button-search-go"].OnEvent(gowd.OnClick, a.buttonSearchClicked)
+	a.em["button-save-search"].OnEvent(gowd.OnClick, a.buttonSaveSearchClicked)
+	a.em["button-new-case"].OnEvent(gowd.OnClick, a.buttonNewCaseClicked)
+	a.em["select-case"].OnEvent(gowd.OnChange, a.selectCaseChanged)
 	a.em["button-loadevents"].OnEvent(gowd.OnClick, a.buttonLoadEventsClicked)
+	a.em["button-import"].OnEvent(gowd.OnClick, a.buttonImportClicked)
+	a.em["button-reindex"].OnEvent(gowd.OnClick, a.buttonReindexClicked)
 	a.em["menubutton-load"].OnEvent(gowd.OnClick, a.menuButttonLoadClicked)
 	a.em["menubutton-sessions"].OnEvent(gowd.OnClick, a.menuButttonSessionsClicked)
 	a.em["menubutton-search"].OnEvent(gowd.OnClick, a.menuButttonSearchClicked)
 
 	a.em["button-errros"].OnEvent(gowd.OnClick, a.menuButttonErrorsClicked)
 	a.em["menubutton-errors"].OnEvent(gowd.OnClick, a.menuButttonErrorsClicked)
+	a.em["button-findings"].OnEvent(gowd.OnClick, a.menuButtonFindingsClicked)
+	a.em["menubutton-findings"].OnEvent(gowd.OnClick, a.menuButtonFindingsClicked)
 	a.content.SetElement(a.loadPage)
+	a.applyConfig()
 	return a, nil
 }
 
+//applyConfig fills the load form with the settings of the data directory
+func (a *app) applyConfig() {
+	c, err := loadConfig()
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	for id, value := range c.inputs() {
+		if *value != "" {
+			a.em[id].SetValue(*value)
+		}
+	}
+}
+
+//saveConfig keeps the values of the load form in the data directory
+func (a *app) saveConfig() {
+	var c config
+	for id, value := range c.inputs() {
+		*value = a.em[id].GetValue()
+	}
+	err := c.save()
+	if err != nil {
+		log.Println(err)
+	}
+}
+
 func (a *app) loadFromTemplate(name string) (*gowd.Element, error) {
-	data, err := ioutil.ReadFile(name)
+	data, err := templates.ReadFile(name)
 	if err != nil {
 		return nil, err
 	}
@@ -82,7 +129,7 @@ func (a *app) loadFromTemplate(name string) (*gowd.Element, error) {
 }
 
 func (a *app) addFromTemplate(parent *gowd.Element, name string) error {
-	data, err := ioutil.ReadFile(name)
+	data, err := templates.ReadFile(name)
 	if err != nil {
 		return err
 	}
@@ -97,20 +144,123 @@ func (a *app) addFromTemplate(parent *gowd.Element, name string) error {
 }
 
 func (a *app) run() error {
-	err := cloudtrail.LoadFromFile()
+	err := a.openCase(caseName)
 	if err != nil {
 		return err
 	}
-	defer cloudtrail.SaveToFile()
-	analyzer.AddAnalyzer(new(assumerole.SessionAnalyzer))
-	a.indexer, err = analyzer.NewBleveAnalyzer("korra.db")
+	defer func() {
+		a.engine.Save()
+		a.engine.Close()
+	}()
+	//start the ui loop
+	return gowd.Run(a.body)
+}
+
+//openCase saves and closes the current case and opens the named one
+func (a *app) openCase(name string) error {
+	if a.engine != nil && name == caseName {
+		return nil
+	}
+	engine, err := cases().Open(name, true)
 	if err != nil {
 		return err
 	}
-	defer a.indexer.Close()
-	analyzer.AddAnalyzer(a.indexer)
-	//start the ui loop
-	return gowd.Run(a.body)
+	if a.engine != nil {
+		err = a.engine.Save()
+		if err != nil {
+			log.Println(err)
+		}
+		a.engine.Close()
+	}
+	a.engine = engine
+	caseName = name
+	a.search = analyzer.SearchOptions{}
+	a.em["progress-row"].RemoveElements()
+	a.em["div-results"].RemoveElements()
+	a.em["div-event-detail"].RemoveElements()
+	a.renderCases()
+	a.renderSavedSearches()
+	a.checkIndex()
+	return nil
+}
+
+//renderCases lists the cases in the case switcher
+func (a *app) renderCases() {
+	names, err := cases().List()
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	sel := a.em["select-case"]
+	sel.RemoveElements()
+	for _, name := range names {
+		option := bootstrap.NewElement("option", "")
+		option.SetAttribute("value", name)
+		if name == caseName {
+			option.SetAttribute("selected", "selected")
+		}
+		option.AddElement(gowd.NewText(name))
+		sel.AddElement(option)
+	}
+}
+
+//setBusy marks a load as running or done, the case switcher and new case button are disabled while it runs
+func (a *app) setBusy(busy bool) {
+	a.busyMutex.Lock()
+	defer a.busyMutex.Unlock()
+	a.busy = busy
+	for _, id := range []string{"select-case", "button-new-case"} {
+		if busy {
+			a.em[id].Disable()
+		} else {
+			a.em[id].Enable()
+		}
+	}
+}
+
+func (a *app) isBusy() bool {
+	a.busyMutex.Lock()
+	defer a.busyMutex.Unlock()
+	return a.busy
+}
+
+func (a *app) selectCaseChanged(sender *gowd.Element, event *gowd.EventElement) {
+	if a.isBusy() {
+		gowd.Alert("Wait for the running load to finish before switching cases")
+		a.renderCases()
+		return
+	}
+	err := a.openCase(sender.GetValue())
+	if err != nil {
+		gowd.Alert(fmt.Sprintf("%v", err))
+		a.renderCases()
+		return
+	}
+	a.content.SetElement(a.loadPage)
+}
+
+//buttonNewCaseClicked creates a case from the events matching the current search and opens it
+func (a *app) buttonNewCaseClicked(sender *gowd.Element, event *gowd.EventElement) {
+	if a.isBusy() {
+		gowd.Alert("Wait for the running load to finish before creating a case")
+		return
+	}
+	if a.search.MatchesAll() {
+		gowd.Alert("Search for the events of the new case first, an empty search matches all events")
+		return
+	}
+	name := a.em["input-case-name"].GetValue()
+	_, err := cases().CreateFrom(context.Background(), name, a.engine, a.search, nil)
+	if err == nil {
+		err = a.openCase(name)
+	}
+	if err != nil {
+		gowd.Alert(fmt.Sprintf("%v", err))
+		a.renderCases()
+		return
+	}
+	a.em["input-case-name"].SetValue("")
+	a.content.SetElement(a.searchPage)
 }
 
 //shows a modal dialog with the provided title and content
@@ -120,15 +270,9 @@ func (a *app) showModal(title string, body *gowd.Element) {
 	gowd.ExecJS(`$('#modal').modal('show');`)
 }
 
-func (a *app) errorLinkButtonClicked(sender *gowd.Element, event *gowd.EventElement) {
-	a.em["div-event"].RemoveElements()
-	a.em["div-event"].AddHTML(fmt.Sprintf("<code>%v</code>", sender.Object), nil)
-	gowd.ExecJS("hljs.highlightBlock(document.getElementById('div-event'));")
-	gowd.ExecJS("$('#table-errors').DataTable();")
-}
-
 func (a *app) menuButttonSearchClicked(sender *gowd.Element, event *gowd.EventElement) {
 	a.content.SetElement(a.searchPage)
+	a.renderSavedSearches()
 }
 
 func (a *app) menuButttonErrorsClicked(sender *gowd.Element, event *gowd.EventElement) {
@@ -140,7 +284,7 @@ func (a *app) menuButttonErrorsClicked(sender *gowd.Element, event *gowd.EventEl
 	tableErrors.AddHeader("Error").SetAttribute("scope", "col")
 	tableErrors.Head.SetAttribute("scope", "row")
 	a.em["div-table-errors"].SetElement(tableErrors.Element)
-	for _, ee := range cloudtrail.ErrorEvents() {
+	for _, ee := range a.engine.Events.ErrorEvents() {
 		row := tableErrors.AddRow()
 		link := bootstrap.NewLinkButton(ee.Name)
 		json, _ := ee.JSONString("<br>", "&nbsp;&nbsp;")
@@ -153,6 +297,69 @@ func (a *app) menuButttonErrorsClicked(sender *gowd.Element, event *gowd.EventEl
 	a.content.SetElement(a.errorsPage)
 }
 
+//findingAction is the object of a finding status button
+type findingAction struct {
+	id     string
+	status findings.Status
+}
+
+func (a *app) menuButtonFindingsClicked(sender *gowd.Element, event *gowd.EventElement) {
+	a.renderFindings()
+	a.content.SetElement(a.findingsPage)
+}
+
+func (a *app) findingStatusClicked(sender *gowd.Element, event *gowd.EventElement) {
+	action := sender.Object.(findingAction)
+	err := a.engine.Findings.SetStatus(action.id, action.status)
+	if err == nil {
+		err = a.engine.Findings.Save()
+	}
+	if err != nil {
+		gowd.Alert(fmt.Sprintf("%v", err))
+	}
+	a.renderFindings()
+}
+
+//renderFindings fills the findings table
+func (a *app) renderFindings() {
+	tableFindings := bootstrap.NewTable("table align-items-center table-flush")
+	tableFindings.SetID("table-findings")
+	for _, header := range []string{"Severity", "Finding", "Principal", "Source IP", "User Agent", "Event", "Status", ""} {
+		tableFindings.AddHeader(header).SetAttribute("scope", "col")
+	}
+	tableFindings.Head.SetAttribute("scope", "row")
+	a.em["div-table-findings"].SetElement(tableFindings.Element)
+	for _, f := range a.engine.Findings.All() {
+		row := tableFindings.AddRow()
+		row.AddCells(f.Severity.String(), f.Title, f.Principal, f.Evidence["sourceIPAddress"], f.Evidence["userAgent"])
+		cell := gowd.NewElement("td")
+		if len(f.EventIDs) > 0 {
+			e, ok := a.engine.Events.Get(f.EventIDs[0])
+			if ok {
+				link := bootstrap.NewLinkButton(fmt.Sprintf("%v (%v)", e.Name, len(f.EventIDs)))
+				json, _ := e.RawJSONString("<br>", "&nbsp;&nbsp;")
+				json = base64.StdEncoding.EncodeToString([]byte(json))
+				link.SetAttribute("onclick", fmt.Sprintf("set_code('div-finding-event','%v');", json))
+				cell.AddElement(link)
+			}
+		}
+		row.AddElement(cell)
+		row.AddCells(string(f.Status))
+		actions := gowd.NewElement("td")
+		for _, status := range []findings.Status{findings.StatusAcknowledged, findings.StatusFalsePositive, findings.StatusOpen} {
+			if status == f.Status {
+				continue
+			}
+			button := bootstrap.NewLinkButton(string(status))
+			button.SetClass("btn btn-sm btn-secondary")
+			button.Object = findingAction{id: f.ID, status: status}
+			button.OnEvent(gowd.OnClick, a.findingStatusClicked)
+			actions.AddElement(button)
+		}
+		row.AddElement(actions)
+	}
+}
+
 func (a *app) sessionClicked(sender *gowd.Element, event *gowd.EventElement) {
 	script := `var nodes = new vis.DataSet([
 		{ id: 1, label: 'User: URI', title: "Amazon" },
@@ -195,10 +402,12 @@ func (a *app) sessionClicked(sender *gowd.Element, event *gowd.EventElement) {
 }
 
 func (a *app) menuButttonSessionsClicked(sender *gowd.Element, event *gowd.EventElement) {
-	a.em["span-total-read"].SetText(fmt.Sprintf("%v", len(cloudtrail.Events)))
-	a.em["span-assume-role-session"].SetText(fmt.Sprintf("%v", len(assumerole.Sessions)))
-	errorEvents := cloudtrail.ErrorEvents()
+	a.em["span-total-read"].SetText(fmt.Sprintf("%v", a.engine.Events.Len()))
+	a.em["span-assume-role-session"].SetText(fmt.Sprintf("%v", a.engine.Sessions.Len()))
+	errorEvents := a.engine.Events.ErrorEvents()
 	a.em["button-errros"].SetText(fmt.Sprintf("%v", len(errorEvents)))
+	openFindings := a.engine.Findings.Find(findings.Filter{Open: true})
+	a.em["button-findings"].SetText(fmt.Sprintf("%v", len(openFindings)))
 
 	a.em["div-table-assume-roles"].RemoveElements()
 	tar := bootstrap.NewTable("table align-items-center table-flush")
@@ -208,7 +417,7 @@ func (a *app) menuButttonSessionsClicked(sender *gowd.Element, event *gowd.Event
 	tar.Head.SetAttribute("scope", "row")
 	a.em["div-table-assume-roles"].AddElement(tar.Element)
 
-	for _, ars := range assumerole.Sessions {
+	for _, ars := range a.engine.Sessions.List() {
 		link := bootstrap.NewLinkButton(ars.Name)
 		link.Object = ars
 		link.OnEvent(gowd.OnClick, a.sessionClicked)
@@ -228,61 +437,305 @@ func (a *app) menuButttonLoadClicked(sender *gowd.Element, event *gowd.EventElem
 	a.content.SetElement(a.loadPage)
 }
 
-//loads and analyzes events
-func (a *app) loadEvents() {
+//startLoading shows a fresh progress row and loads in the background until done or cancelled
+func (a *app) startLoading(load func(ctx context.Context, progress analyzer.ProgressFunc) error) error {
+	err := a.showProgressRow()
+	if err != nil {
+		return err
+	}
+	a.setBusy(true)
+	ctx, cancel := context.WithCancel(context.Background())
+	a.em["button-cancel"].OnEvent(gowd.OnClick, func(sender *gowd.Element, event *gowd.EventElement) {
+		sender.SetClass("disabled")
+		cancel()
+	})
+	go func() {
+		defer cancel()
+		a.loadEvents(ctx, load)
+	}()
+	return nil
+}
+
+//loads and analyzes events using the provided loader
+func (a *app) loadEvents(ctx context.Context, load func(ctx context.Context, progress analyzer.ProgressFunc) error) {
 	log.SetOutput(a)
 	log.SetFlags(log.Flags() &^ (log.Ldate | log.Ltime))
 	defer func() {
+		a.setBusy(false)
 		a.em["button-loadevents"].UnsetClass("disabled")
+		a.em["button-import"].UnsetClass("disabled")
+		a.em["button-reindex"].UnsetClass("disabled")
+		a.em["button-cancel"].Hide()
 		a.onFetchProgress(100, 100)
 		a.body.Render()
 	}()
-	err := analyzer.LoadAndAnalyze(a.onFetchProgress)
-	if err != nil {
+	err := load(ctx, a.onFetchProgress)
+	a.renderTargets()
+	a.renderStats()
+	a.renderSavedSearches()
+	if err != nil && ctx.Err() == nil {
 		gowd.Alert(fmt.Sprintf("%v", err))
 		return
 	}
 	html := `<p class="mt-3 mb-0 text-muted text-sm">
 	<span class="text-success mr-2"> <i class="fa fa-chart-line"></i> %v </span>
 	<span class="text-nowrap"> sessions loaded.</span></p>`
-	a.em["fetch-card-body"].AddHTML(fmt.Sprintf(html, len(assumerole.Sessions)), nil)
+	a.em["fetch-card-body"].AddHTML(fmt.Sprintf(html, a.engine.Sessions.Len()), nil)
 	link := bootstrap.NewLinkButton("Analyze")
 	link.SetClass("btn btn-sm btn-primary")
 	link.OnEvent(gowd.OnClick, a.menuButttonSessionsClicked)
 	a.em["fetch-card-body"].AddElement(link)
 }
 
-func (a *app) createDocLink(docid string) *gowd.Element {
-	doc, err := a.indexer.Index.Document(docid)
-	if err != nil {
-		return gowd.NewText(fmt.Sprintf("%v", err))
+//renderTargets shows the accounts and regions of the last load and their status
+func (a *app) renderTargets() {
+	targets := a.engine.Targets()
+	if len(targets) < 2 {
+		return
 	}
-	text := ""
-	for _, f := range doc.Fields {
-		text += fmt.Sprintf("%v:%v; ", f.Name(), string(f.Value()))
-		if len(text) > 100 {
-			break
+	html := `<div class="table-responsive mt-3"><table class="table align-items-center table-flush">
+	<thead class="thead-light"><tr><th scope="col">Account</th><th scope="col">Region</th>
+	<th scope="col">Loaded</th><th scope="col">Status</th></tr></thead><tbody>`
+	for _, t := range targets {
+		status := `<span class="text-success">done</span>`
+		if t.Error != "" {
+			status = fmt.Sprintf(`<span class="text-danger">%v</span>`, template.HTMLEscapeString(t.Error))
 		}
+		html += fmt.Sprintf("<tr><td>%v</td><td>%v</td><td>%v</td><td>%v</td></tr>", t.Account, t.Region, t.Loaded, status)
 	}
-	link := bootstrap.NewLinkButton(text[:100])
-	link.Object = fmt.Sprintf("%v - lala", doc.GoString())
-	link.OnEvent(gowd.OnClick, a.errorLinkButtonClicked)
+	html += "</tbody></table></div>"
+	a.em["fetch-card-body"].AddHTML(html, nil)
+}
+
+//renderStats shows the throughput and latency of every analyzer in the last run
+func (a *app) renderStats() {
+	html := `<div class="table-responsive mt-3"><table class="table align-items-center table-flush">
+	<thead class="thead-light"><tr><th scope="col">Analyzer</th><th scope="col">Workers</th>
+	<th scope="col">Events</th><th scope="col">Errors</th><th scope="col">Events/s</th>
+	<th scope="col">Mean</th><th scope="col">Max</th></tr></thead><tbody>`
+	for _, s := range a.engine.Stats() {
+		html += fmt.Sprintf("<tr><td>%v</td><td>%v</td><td>%v</td><td>%v</td><td>%.0f</td><td>%v</td><td>%v</td></tr>",
+			s.Name, s.Workers, s.Events, s.Errors, s.Throughput(), s.MeanLatency(), s.MaxLatency)
+	}
+	html += "</tbody></table></div>"
+	a.em["fetch-card-body"].AddHTML(html, nil)
+}
+
+//createDocLink returns a link to the detail of a search hit
+func (a *app) createDocLink(hit *search.DocumentMatch) *gowd.Element {
+	text := fmt.Sprintf("%v %v", hit.Fields["eventTime"], hit.Fields["eventName"])
+	if arn, ok := hit.Fields["userIdentity.arn"].(string); ok && arn != "" {
+		text += " by " + arn
+	}
+	link := bootstrap.NewLinkButton(text)
+	link.Object = hit.ID
+	link.OnEvent(gowd.OnClick, a.docLinkClicked)
 	return link
 }
 
+func (a *app) docLinkClicked(sender *gowd.Element, event *gowd.EventElement) {
+	a.showEventDetail(sender.Object.(string))
+}
+
+//showEventDetail shows the original record, identity, session and neighbours of an event
+//with pivots to search for events sharing its IP, access key or ARN
+func (a *app) showEventDetail(id string) {
+	d, err := a.engine.Detail(id)
+	if err != nil {
+		gowd.Alert(fmt.Sprintf("%v", err))
+		return
+	}
+	div := a.em["div-event-detail"]
+	div.RemoveElements()
+	err = a.addFromTemplate(div, "event-detail.html")
+	if err != nil {
+		gowd.Alert(fmt.Sprintf("%v", err))
+		return
+	}
+	a.em["text-detail-title"].SetText(fmt.Sprintf("%v %v", d.Event.Name, d.Event.Time.Format(time.RFC3339)))
+	a.em["button-detail-close"].OnEvent(gowd.OnClick, func(sender *gowd.Element, event *gowd.EventElement) {
+		div.RemoveElements()
+	})
+
+	for _, p := range d.Pivots {
+		button := bootstrap.NewElement("a", "btn btn-sm btn-outline-primary mb-1")
+		button.SetAttribute("href", "#")
+		button.SetAttribute("title", p.Value)
+		button.AddElement(gowd.NewText(fmt.Sprintf("Search by %v", strings.ToLower(p.Label))))
+		opts := p.Options()
+		button.OnEvent(gowd.OnClick, func(sender *gowd.Element, event *gowd.EventElement) {
+			opts.Size = a.search.Size
+			a.runSearch(opts)
+		})
+		a.em["div-detail-pivots"].AddElement(button)
+	}
+
+	identity := bootstrap.NewTable("table table-sm")
+	for _, f := range d.Identity {
+		row := identity.AddRow()
+		row.AddCells(f.Name, f.Value)
+	}
+	a.em["div-detail-identity"].AddElement(identity.Element)
+
+	if d.Session != nil {
+		a.em["div-detail-session"].AddElement(gowd.NewText(fmt.Sprintf("%v (%v), %v events from %v",
+			d.Session.Name, d.Session.AssumedRoleARN, len(d.Session.Events), d.Session.IPs())))
+	} else {
+		a.em["div-detail-session"].AddElement(gowd.NewStyledText("Not part of an assume role session", gowd.ItalicText))
+	}
+
+	for _, n := range d.Neighbours {
+		text := fmt.Sprintf("%v %v %v", n.Time.Format(time.RFC3339), n.Name, n.SourceIPAddress)
+		if n.ID == d.Event.ID {
+			a.em["div-detail-neighbours"].AddElement(bootstrap.NewElement("div", "", gowd.NewStyledText(text, gowd.StrongText)))
+			continue
+		}
+		link := bootstrap.NewLinkButton(text)
+		link.Object = n.ID
+		link.OnEvent(gowd.OnClick, a.docLinkClicked)
+		a.em["div-detail-neighbours"].AddElement(bootstrap.NewElement("div", "", link))
+	}
+
+	a.em["code-detail-raw"].SetText(d.Raw)
+	gowd.ExecJS("hljs.highlightBlock(document.getElementById('code-detail-raw'));")
+}
+
 func (a *app) buttonSearchClicked(sender *gowd.Element, event *gowd.EventElement) {
 	input := a.em["input-search"]
-	term := input.GetValue()
+	opts := analyzer.SearchOptions{
+		Query: input.GetValue(),
+		Sort:  a.em["select-search-sort"].GetValue(),
+	}
+	var err error
+	opts.Size, err = strconv.Atoi(a.em["select-search-size"].GetValue())
+	if err != nil {
+		gowd.Alert(fmt.Sprintf("%v", err))
+		return
+	}
+	opts.Start, err = analyzer.ParseTime(a.em["input-search-start"].GetValue())
+	if err != nil {
+		gowd.Alert(fmt.Sprintf("%v", err))
+		return
+	}
+	opts.End, err = analyzer.ParseTime(a.em["input-search-end"].GetValue())
+	if err != nil {
+		gowd.Alert(fmt.Sprintf("%v", err))
+		return
+	}
 	input.AutoFocus()
-	input.SetValue("")
-	query := bleve.NewQueryStringQuery(term)
-	req := bleve.NewSearchRequest(query)
+	a.runSearch(opts)
+}
+
+//buttonSaveSearchClicked saves the search shown, a window replaces its start time
+func (a *app) buttonSaveSearchClicked(sender *gowd.Element, event *gowd.EventElement) {
+	s := analyzer.SavedSearch{
+		Name:    a.em["input-save-name"].GetValue(),
+		Query:   a.search.Query,
+		Window:  a.em["input-save-window"].GetValue(),
+		Start:   a.search.Start,
+		End:     a.search.End,
+		Filters: a.search.Filters,
+		Sort:    a.search.Sort,
+	}
+	severity := a.em["select-save-hunt"].GetValue()
+	if severity != "" {
+		var err error
+		s.Hunt = true
+		s.Severity, err = findings.ParseSeverity(severity)
+		if err != nil {
+			gowd.Alert(fmt.Sprintf("%v", err))
+			return
+		}
+	}
+	err := a.engine.Searches.Put(s)
+	if err == nil {
+		err = a.engine.Searches.Save()
+	}
+	if err != nil {
+		gowd.Alert(fmt.Sprintf("%v", err))
+		return
+	}
+	a.em["input-save-name"].SetValue("")
+	a.renderSavedSearches()
+}
+
+//renderSavedSearches lists the saved searches, with the hits of the last run of hunts
+func (a *app) renderSavedSearches() {
+	div := a.em["div-saved-searches"]
+	div.RemoveElements()
+	for _, s := range a.engine.Searches.List() {
+		row := bootstrap.NewElement("div", "d-flex align-items-center mb-1")
+		link := bootstrap.NewElement("a", "flex-grow-1")
+		link.SetAttribute("href", "#")
+		link.SetAttribute("title", s.Query)
+		link.AddElement(gowd.NewText(s.Name))
+		saved := s
+		link.OnEvent(gowd.OnClick, func(sender *gowd.Element, event *gowd.EventElement) {
+			opts, err := saved.Options(time.Now())
+			if err != nil {
+				gowd.Alert(fmt.Sprintf("%v", err))
+				return
+			}
+			opts.Size = a.search.Size
+			a.runSearch(opts)
+		})
+		row.AddElement(link)
+		if s.Hunt {
+			badge := bootstrap.NewElement("span", "badge badge-pill badge-warning mr-1")
+			text := "hunt"
+			if run, ok := s.LastRun(); ok {
+				text = fmt.Sprintf("%v hits, %v new", run.Hits, run.New)
+				badge.SetAttribute("title", fmt.Sprintf("last run %v %v", run.Time.Format(time.RFC3339), run.Error))
+			}
+			badge.AddElement(gowd.NewText(text))
+			row.AddElement(badge)
+		}
+		remove := bootstrap.NewElement("a", "text-danger")
+		remove.SetAttribute("href", "#")
+		remove.SetAttribute("title", "Delete")
+		remove.AddElement(gowd.NewText("\u00d7"))
+		remove.OnEvent(gowd.OnClick, func(sender *gowd.Element, event *gowd.EventElement) {
+			err := a.engine.Searches.Delete(saved.Name)
+			if err == nil {
+				err = a.engine.Searches.Save()
+			}
+			if err != nil {
+				gowd.Alert(fmt.Sprintf("%v", err))
+			}
+			a.renderSavedSearches()
+		})
+		row.AddElement(remove)
+		div.AddElement(row)
+	}
+}
+
+func (a *app) buttonSearchPrevClicked(sender *gowd.Element, event *gowd.EventElement) {
+	a.runSearch(a.search.Prev())
+}
+
+func (a *app) buttonSearchNextClicked(sender *gowd.Element, event *gowd.EventElement) {
+	a.runSearch(a.search.Next())
+}
+
+//runSearch shows a page of search results, the options are kept for paging
+func (a *app) runSearch(opts analyzer.SearchOptions) {
+	req, err := opts.Request()
+	if err != nil {
+		gowd.Alert(fmt.Sprintf("%v", err))
+		return
+	}
 	req.Highlight = bleve.NewHighlightWithStyle(html.Name)
-	sr, err := a.indexer.Index.Search(req)
+	err = a.engine.Indexer.AddFacets(req, opts)
 	if err != nil {
 		gowd.Alert(fmt.Sprintf("%v", err))
 		return
 	}
+	sr, err := a.engine.Indexer.Search(req)
+	if err != nil {
+		gowd.Alert(fmt.Sprintf("%v", err))
+		return
+	}
+	a.search = opts
 
 	div := a.em["div-results"]
 	div.RemoveElements()
@@ -291,14 +744,29 @@ func (a *app) buttonSearchClicked(sender *gowd.Element, event *gowd.EventElement
 		gowd.Alert(fmt.Sprintf("%v", err))
 	}
 
-	summary := fmt.Sprintf("%d matches, showing %d through %d, took %s\n", sr.Total, sr.Request.From+1, sr.Request.From+len(sr.Hits), sr.Took)
+	summary := fmt.Sprintf("%d matches, took %s", sr.Total, sr.Took)
+	if len(sr.Hits) > 0 {
+		summary = fmt.Sprintf("%d matches, showing %d through %d, took %s", sr.Total, opts.From+1, opts.From+len(sr.Hits), sr.Took)
+	}
 	a.em["text-search-summary"].SetText(summary)
+	prev := a.em["button-prev"]
+	prev.OnEvent(gowd.OnClick, a.buttonSearchPrevClicked)
+	if opts.From == 0 {
+		prev.SetClass("btn btn-sm btn-primary disabled")
+	}
+	next := a.em["button-next"]
+	next.OnEvent(gowd.OnClick, a.buttonSearchNextClicked)
+	if uint64(opts.From+len(sr.Hits)) >= sr.Total {
+		next.SetClass("btn btn-sm btn-primary disabled")
+	}
+	a.renderHistogram(sr)
+	a.renderFacets(sr)
 	divsr := a.em["div-sr"]
 
 	for i, hit := range sr.Hits {
-		link := a.createDocLink(hit.ID)
+		link := a.createDocLink(hit)
 		header := bootstrap.NewElement("h4", "heading-small mb-4")
-		header.AddElement(gowd.NewText(fmt.Sprintf("#%v", i)))
+		header.AddElement(gowd.NewText(fmt.Sprintf("#%v", opts.From+i+1)))
 		header.AddHTML("&nbsp;", nil)
 		header.AddElement(link)
 		header.AddHTML("&nbsp;", nil)
@@ -321,38 +789,184 @@ func (a *app) buttonSearchClicked(sender *gowd.Element, event *gowd.EventElement
 		divsr.AddElement(gowd.NewElement("hr"))
 
 	}
-	// if len(sr.Facets) > 0 {
-	// 	html += fmt.Sprintf("Facets:\n")
-	// 	for fn, f := range sr.Facets {
-	// 		html += fmt.Sprintf("%s(%d)\n", fn, f.Total)
-	// 		for _, t := range f.Terms {
-	// 			hmtl += fmt.Sprintf("\t%s(%d)\n", t.Term, t.Count)
-	// 		}
-	// 		if f.Other != 0 {
-	// 			html += fmt.Sprintf("\tOther(%d)\n", f.Other)
-	// 		}
-	// 	}
-	// }
+}
+
+//renderHistogram shows the events per time bucket, clicking a bar limits the search to its time range
+func (a *app) renderHistogram(sr *bleve.SearchResult) {
+	div := a.em["div-search-histogram"]
+	buckets := analyzer.Histogram(sr)
+	max := 1
+	for _, b := range buckets {
+		if b.Count > max {
+			max = b.Count
+		}
+	}
+	for _, b := range buckets {
+		bar := bootstrap.NewElement("a", "bg-primary")
+		bar.SetAttribute("href", "#")
+		bar.SetAttribute("title", fmt.Sprintf("%v: %v", b.Start.Format(time.RFC3339), b.Count))
+		bar.SetAttribute("style", fmt.Sprintf("flex: 1; margin-right: 1px; min-height: 1px; height: %v%%;", b.Count*100/max))
+		bucket := b
+		bar.OnEvent(gowd.OnClick, func(sender *gowd.Element, event *gowd.EventElement) {
+			opts := a.search
+			opts.From = 0
+			opts.Start = bucket.Start
+			opts.End = bucket.End.Add(-time.Nanosecond)
+			a.runSearch(opts)
+		})
+		div.AddElement(bar)
+	}
+}
+
+//renderFacets shows the active filters and the top terms of every facet field as chips,
+//clicking a term refines the search, clicking a filter removes it
+func (a *app) renderFacets(sr *bleve.SearchResult) {
+	filters := a.em["div-search-filters"]
+	for _, field := range analyzer.FacetFields {
+		term, ok := a.search.Filters[field]
+		if !ok {
+			continue
+		}
+		chip := bootstrap.NewElement("a", "badge badge-pill badge-success mr-1")
+		chip.SetAttribute("href", "#")
+		chip.AddElement(gowd.NewText(fmt.Sprintf("%v: %v \u00d7", field, term)))
+		unrefined := a.search.Unrefine(field)
+		chip.OnEvent(gowd.OnClick, func(sender *gowd.Element, event *gowd.EventElement) {
+			a.runSearch(unrefined)
+		})
+		filters.AddElement(chip)
+	}
+	div := a.em["div-search-facets"]
+	for _, field := range analyzer.FacetFields {
+		terms := analyzer.Terms(sr, field)
+		if len(terms) == 0 {
+			continue
+		}
+		row := bootstrap.NewElement("div", "mb-2")
+		row.AddElement(gowd.NewStyledText(field, gowd.StrongText))
+		row.AddHTML("&nbsp;", nil)
+		for _, t := range terms {
+			chip := bootstrap.NewElement("a", "badge badge-pill badge-primary mr-1")
+			chip.SetAttribute("href", "#")
+			chip.AddElement(gowd.NewText(fmt.Sprintf("%v (%d)", t.Term, t.Count)))
+			refined := a.search.Refine(field, t.Term)
+			chip.OnEvent(gowd.OnClick, func(sender *gowd.Element, event *gowd.EventElement) {
+				a.runSearch(refined)
+			})
+			row.AddElement(chip)
+		}
+		if sr.Facets[field].Other > 0 {
+			row.AddElement(gowd.NewStyledText(fmt.Sprintf("other (%d)", sr.Facets[field].Other), gowd.ItalicText))
+		}
+		div.AddElement(row)
+	}
 }
 
 func (a *app) buttonLoadEventsClicked(sender *gowd.Element, event *gowd.EventElement) {
-	a.em["button-loadevents"].SetClass("disabled")
-	analyzer.Options.Region = a.em["input-region"].GetValue()
+	opts := a.engine.Options()
+	opts.Region = a.em["input-region"].GetValue()
+	opts.Bucket = a.em["input-bucket"].GetValue()
+	opts.Prefix = a.em["input-prefix"].GetValue()
+	opts.FullReload = a.em["input-loadmode"].GetValue() == "full"
+	opts.Regions = splitList(a.em["input-regions"].GetValue())
+	opts.Accounts = splitList(a.em["input-accounts"].GetValue())
+	opts.AuditRole = a.em["input-auditrole"].GetValue()
+	opts.Profile = a.em["input-profile"].GetValue()
+	opts.Endpoint = a.em["input-endpoint"].GetValue()
+	opts.AccessKey = a.em["input-accesskey"].GetValue()
+	opts.Secret = a.em["input-secret"].GetValue()
+	opts.SessionToken = a.em["input-sessiontoken"].GetValue()
+	opts.RoleARN = a.em["input-rolearn"].GetValue()
+	opts.ExternalID = a.em["input-externalid"].GetValue()
+	opts.MFASerial = a.em["input-mfaserial"].GetValue()
+	opts.MFAToken = a.em["input-mfatoken"].GetValue()
 	var err error
-	analyzer.Options.MaxOnlineEvents, err = strconv.Atoi(a.em["input-maxevents"].GetValue())
+	opts.MaxOnlineEvents, err = strconv.Atoi(a.em["input-maxevents"].GetValue())
 	if err != nil {
 		gowd.Alert(fmt.Sprintf("%v", err))
 		return
 	}
-	progressRow := a.em["progress-row"]
-	progressRow.RemoveElements()
-	err = a.addFromTemplate(progressRow, "progress.html")
+	opts.RequestsPerSecond, err = strconv.ParseFloat(a.em["input-rps"].GetValue(), 64)
+	if err != nil {
+		gowd.Alert(fmt.Sprintf("%v", err))
+		return
+	}
+	opts.StartTime, err = analyzer.ParseTime(a.em["input-start"].GetValue())
+	if err != nil {
+		gowd.Alert(fmt.Sprintf("%v", err))
+		return
+	}
+	opts.EndTime, err = analyzer.ParseTime(a.em["input-end"].GetValue())
+	if err != nil {
+		gowd.Alert(fmt.Sprintf("%v", err))
+		return
+	}
+	opts.Attributes = make(map[string]string)
+	key := a.em["input-attribute-key"].GetValue()
+	if key != "" {
+		opts.Attributes[key] = a.em["input-attribute-value"].GetValue()
+	}
+	a.engine.SetOptions(opts)
+	a.saveConfig()
+	a.em["button-loadevents"].SetClass("disabled")
+	err = a.startLoading(a.engine.LoadAndAnalyze)
+	if err != nil {
+		a.em["button-loadevents"].UnsetClass("disabled")
+		gowd.Alert(fmt.Sprintf("%v", err))
+	}
+}
+
+func (a *app) buttonImportClicked(sender *gowd.Element, event *gowd.EventElement) {
+	path := a.em["input-import-path"].GetValue()
+	if path == "" {
+		gowd.Alert("Please provide a path to import from")
+		return
+	}
+	a.em["button-import"].SetClass("disabled")
+	err := a.startLoading(func(ctx context.Context, progress analyzer.ProgressFunc) error {
+		return a.engine.ImportAndAnalyze(ctx, path, progress)
+	})
+	if err != nil {
+		a.em["button-import"].UnsetClass("disabled")
+		gowd.Alert(fmt.Sprintf("%v", err))
+	}
+}
+
+func (a *app) buttonReindexClicked(sender *gowd.Element, event *gowd.EventElement) {
+	a.em["button-reindex"].SetClass("disabled")
+	err := a.startLoading(a.engine.Reindex)
 	if err != nil {
+		a.em["button-reindex"].UnsetClass("disabled")
 		gowd.Alert(fmt.Sprintf("%v", err))
+	}
+}
+
+//checkIndex offers to rebuild the search index if it does not match the stored events
+func (a *app) checkIndex() {
+	drift, err := a.engine.IndexDrift()
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if !drift.Drifted() {
+		return
+	}
+	html := `<div class="col-xl-12 order-xl-1"><div class="alert alert-warning" role="alert">
+	<strong>Search index is out of date</strong> (%v).
+	<a href="#" class="btn btn-sm btn-secondary ml-2" id="button-repair-index">Reindex</a></div></div>`
+	_, err = a.em["progress-row"].AddHTML(fmt.Sprintf(html, drift), a.em)
+	if err != nil {
+		log.Println(err)
 		return
 	}
+	a.em["button-repair-index"].OnEvent(gowd.OnClick, a.buttonReindexClicked)
+}
 
-	go a.loadEvents()
+//showProgressRow replaces the progress row on the load page with a fresh progress card
+func (a *app) showProgressRow() error {
+	progressRow := a.em["progress-row"]
+	progressRow.RemoveElements()
+	return a.addFromTemplate(progressRow, "progress.html")
 }
 
 //onFetchProgress handler for when fetch progress needs to be updated
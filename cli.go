@@ -0,0 +1,867 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/blevesearch/bleve"
+	"github.com/dtylman/korra/analyzer"
+	"github.com/dtylman/korra/analyzer/findings"
+)
+
+//exit codes of the headless mode
+const (
+	exitOK       = 0
+	exitError    = 1
+	exitFindings = 2
+)
+
+//errFindings is returned by commands that produced findings at or above the -fail-on severity
+var errFindings = errors.New("findings at or above the requested severity were produced")
+
+//errUsage is returned for invalid command flags, the flag set already printed the error and usage
+var errUsage = errors.New("invalid flags")
+
+//command is a headless sub command
+type command struct {
+	name  string
+	usage string
+	run   func(args []string) error
+}
+
+var commands = []command{
+	{"load", "load events from AWS (LookupEvents, S3 trail or local import) and analyze them", cmdLoad},
+	{"analyze", "re-analyze and re-index the stored events", cmdAnalyze},
+	{"reindex", "rebuild the search index from the stored events", cmdReindex},
+	{"search", "search the index: search [flags] <query>", cmdSearch},
+	{"save", "save a search: save [flags] <name> <query>", cmdSave},
+	{"searches", "list or delete saved searches and their hunt results", cmdSearches},
+	{"hunt", "run the hunts and report their new hits as findings", cmdHunt},
+	{"sessions", "list assume role sessions", cmdSessions},
+	{"findings", "list analyzer findings", cmdFindings},
+	{"cases", "list, create or delete cases", cmdCases},
+	{"extract", "create a case from a search: extract [flags] <case> <query>", cmdExtract},
+	{"export", "export the case to an archive: export [-o file]", cmdExport},
+	{"import", "create a case from an archive written by export: import <file> <case>", cmdImport},
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: %v [-data dir] [-case name] [command] [flags]\n\nRuns the UI when no command is given. Commands:\n", os.Args[0])
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10v %v\n", c.name, c.usage)
+	}
+	fmt.Fprintln(os.Stderr, "\nOptions:")
+	flag.PrintDefaults()
+}
+
+//runCommand runs a headless sub command and returns the process exit code
+func runCommand(args []string) int {
+	for _, c := range commands {
+		if c.name != args[0] {
+			continue
+		}
+		err := c.run(args[1:])
+		if err == flag.ErrHelp {
+			return exitOK
+		}
+		if err == errUsage {
+			return exitError
+		}
+		if err == errFindings {
+			fmt.Fprintln(os.Stderr, err)
+			return exitFindings
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitError
+		}
+		return exitOK
+	}
+	usage()
+	return exitError
+}
+
+//lastPercent is the last percentage printed by printProgress
+var lastPercent = -1
+
+//printProgress is a ProgressFunc that reports to stderr
+func printProgress(value int, total int) {
+	if total <= 0 {
+		return
+	}
+	percent := 100 * value / total
+	if percent != lastPercent {
+		lastPercent = percent
+		fmt.Fprintf(os.Stderr, "\r%v%%", percent)
+	}
+}
+
+//interruptContext returns a context cancelled by the first interrupt (Ctrl-C)
+func interruptContext() (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	go func() {
+		select {
+		case <-interrupt:
+			fmt.Fprintln(os.Stderr, "\nCancelling...")
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, func() {
+		signal.Stop(interrupt)
+		cancel()
+	}
+}
+
+//openEngine creates an engine over the stored events, with a search index if index is set.
+//Warns if the search index does not match the stored events.
+func openEngine(index bool) (*analyzer.Engine, error) {
+	engine, err := cases().Open(caseName, index)
+	if err != nil || !index {
+		return engine, err
+	}
+	drift, err := engine.IndexDrift()
+	if err != nil {
+		engine.Close()
+		return nil, err
+	}
+	if drift.Drifted() {
+		fmt.Fprintf(os.Stderr, "search index is out of date (%v), run '%v reindex' to rebuild it\n", drift, os.Args[0])
+	}
+	return engine, nil
+}
+
+//parseFlags parses the flags of a command, returns errUsage if they are invalid
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	err := fs.Parse(args)
+	if err != nil && err != flag.ErrHelp {
+		return errUsage
+	}
+	return err
+}
+
+//findingIDs returns the IDs of the findings, see failOn
+func findingIDs(engine *analyzer.Engine) map[string]bool {
+	ids := make(map[string]bool)
+	for _, f := range engine.Findings.All() {
+		ids[f.ID] = true
+	}
+	return ids
+}
+
+//failOn returns errFindings if there are open findings at or above severity, other than the
+//findings of before. Commands that add to the findings pass the findings that existed before they
+//ran, so only the findings they produced count. Commands that analyze all events pass nil, as the
+//analysis reports every finding again.
+func failOn(engine *analyzer.Engine, severity string, before map[string]bool) error {
+	if severity == "" {
+		return nil
+	}
+	min, err := findings.ParseSeverity(severity)
+	if err != nil {
+		return err
+	}
+	for _, f := range engine.Findings.Find(findings.Filter{MinSeverity: min, Open: true}) {
+		if !before[f.ID] {
+			return errFindings
+		}
+	}
+	return nil
+}
+
+func printSummary(engine *analyzer.Engine) {
+	fmt.Printf("%v events, %v sessions, %v findings\n", engine.Events.Len(), engine.Sessions.Len(), engine.Findings.Len())
+}
+
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+//splitList splits a comma separated list, ignoring blanks
+func splitList(value string) []string {
+	list := make([]string, 0)
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+//printTargets prints the accounts and regions of the last load and their status
+func printTargets(engine *analyzer.Engine) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "ACCOUNT\tREGION\tLOADED\tERROR")
+	for _, t := range engine.Targets() {
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\n", t.Account, t.Region, t.Loaded, t.Error)
+	}
+	w.Flush()
+}
+
+//printStats prints the throughput and latency of every analyzer in the last run
+func printStats(engine *analyzer.Engine) {
+	w := tabwriter.NewWriter(os.Stderr, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "ANALYZER\tWORKERS\tEVENTS\tERRORS\tEVENTS/S\tMEAN\tMAX")
+	for _, s := range engine.Stats() {
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%.0f\t%v\t%v\n", s.Name, s.Workers, s.Events, s.Errors, s.Throughput(), s.MeanLatency(), s.MaxLatency)
+	}
+	w.Flush()
+}
+
+//attributesFlag collects repeated -attr Key=Value flags
+type attributesFlag map[string]string
+
+func (af attributesFlag) String() string {
+	return fmt.Sprintf("%v", map[string]string(af))
+}
+
+func (af attributesFlag) Set(value string) error {
+	key, val, err := analyzer.ParseAttribute(value)
+	if err != nil {
+		return err
+	}
+	af[key] = val
+	return nil
+}
+
+//filtersFlag collects repeated -filter field=term flags
+type filtersFlag map[string]string
+
+func (ff filtersFlag) String() string {
+	return fmt.Sprintf("%v", map[string]string(ff))
+}
+
+func (ff filtersFlag) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return fmt.Errorf("Invalid filter '%v', expected field=term", value)
+	}
+	ff[parts[0]] = parts[1]
+	return nil
+}
+
+//timeFlag is a time flag parsed by analyzer.ParseTime
+type timeFlag struct {
+	t *time.Time
+}
+
+func (tf timeFlag) String() string {
+	if tf.t == nil || tf.t.IsZero() {
+		return ""
+	}
+	return tf.t.Format(time.RFC3339)
+}
+
+func (tf timeFlag) Set(value string) error {
+	var err error
+	*tf.t, err = analyzer.ParseTime(value)
+	return err
+}
+
+//credentialFlags adds the AWS credential flags, the default credential chain is used if none is set
+func credentialFlags(fs *flag.FlagSet, opts *analyzer.Options) {
+	fs.StringVar(&opts.Profile, "profile", "", "named profile from ~/.aws/config and ~/.aws/credentials")
+	fs.StringVar(&opts.AccessKey, "access-key", "", "AWS access key ID")
+	fs.StringVar(&opts.Secret, "secret", "", "AWS secret access key")
+	fs.StringVar(&opts.SessionToken, "session-token", "", "AWS session token")
+	fs.StringVar(&opts.RoleARN, "role-arn", "", "role to assume with the profile or access key credentials")
+	fs.StringVar(&opts.ExternalID, "external-id", "", "external ID for -role-arn")
+	fs.StringVar(&opts.MFASerial, "mfa-serial", "", "MFA device serial or ARN for -role-arn")
+	fs.StringVar(&opts.MFAToken, "mfa-token", "", "MFA token code for -mfa-serial or a profile with mfa_serial, prompted for with -mfa-serial if not set")
+}
+
+//promptMFA asks for an MFA token code if one is needed and was not given
+func promptMFA(opts *analyzer.Options) error {
+	if opts.MFASerial == "" || opts.MFAToken != "" {
+		return nil
+	}
+	var err error
+	opts.MFAToken, err = stscreds.StdinTokenProvider()
+	return err
+}
+
+func cmdLoad(args []string) error {
+	var opts analyzer.Options
+	opts.Attributes = make(map[string]string)
+	fs := flag.NewFlagSet("load", flag.ContinueOnError)
+	fs.Var(timeFlag{&opts.StartTime}, "start", "load events from this time (RFC3339 or YYYY-MM-DD[THH:MM], UTC)")
+	fs.Var(timeFlag{&opts.EndTime}, "end", "load events up to this time (RFC3339 or YYYY-MM-DD[THH:MM], UTC)")
+	fs.Var(attributesFlag(opts.Attributes), "attr", "filter by lookup attribute Key=Value, may be repeated. Keys: "+strings.Join(analyzer.LookupAttributes, ", "))
+	fs.StringVar(&opts.Region, "region", "us-west-2", "AWS region")
+	regions := fs.String("regions", "", "comma separated regions to load from, or \"all\" for all enabled regions (default -region)")
+	accounts := fs.String("accounts", "", "comma separated account IDs to load from (default the caller account)")
+	fs.StringVar(&opts.AuditRole, "role", "", "audit role name assumed in every account of -accounts")
+	fs.IntVar(&opts.MaxOnlineEvents, "max", 50, "maximal number of events to load")
+	fs.Float64Var(&opts.RequestsPerSecond, "rps", analyzer.DefaultRequestsPerSecond, "LookupEvents requests per second in every account and region")
+	fs.StringVar(&opts.Bucket, "bucket", "", "S3 bucket holding the trail, uses LookupEvents if empty")
+	fs.StringVar(&opts.Prefix, "prefix", "", "trail prefix inside the bucket")
+	fs.StringVar(&opts.Endpoint, "endpoint", "", "AWS endpoint override (e.g. a local CloudTrail, STS or S3 stand-in)")
+	credentialFlags(fs, &opts)
+	fs.BoolVar(&opts.FullReload, "full", false, "discard stored events and reload everything instead of loading only new events")
+	path := fs.String("import", "", "import from a local directory, file or tarball instead of AWS")
+	fail := fs.String("fail-on", "", "exit with code 2 if findings at or above this severity are produced")
+	err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	opts.Regions = splitList(*regions)
+	opts.Accounts = splitList(*accounts)
+	if *path == "" {
+		err = promptMFA(&opts)
+		if err != nil {
+			return err
+		}
+	}
+
+	engine, err := openEngine(true)
+	if err != nil {
+		return err
+	}
+	defer engine.Close()
+	ctx, cancel := interruptContext()
+	defer cancel()
+	engine.SetOptions(opts)
+	var before map[string]bool
+	if *path == "" && !opts.FullReload {
+		before = findingIDs(engine)
+	}
+	if *path != "" {
+		err = engine.ImportAndAnalyze(ctx, *path, printProgress)
+	} else {
+		err = engine.LoadAndAnalyze(ctx, printProgress)
+	}
+	fmt.Fprintln(os.Stderr)
+	if len(engine.Targets()) > 1 {
+		printTargets(engine)
+	}
+	printStats(engine)
+	if err != nil {
+		return err
+	}
+	printSummary(engine)
+	return failOn(engine, *fail, before)
+}
+
+func cmdAnalyze(args []string) error {
+	fs := flag.NewFlagSet("analyze", flag.ContinueOnError)
+	fail := fs.String("fail-on", "", "exit with code 2 if open findings at or above this severity are produced")
+	err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+
+	engine, err := openEngine(true)
+	if err != nil {
+		return err
+	}
+	defer engine.Close()
+	ctx, cancel := interruptContext()
+	defer cancel()
+	err = engine.Analyze(ctx, printProgress)
+	fmt.Fprintln(os.Stderr)
+	printStats(engine)
+	if err != nil {
+		return err
+	}
+	err = engine.Save()
+	if err != nil {
+		return err
+	}
+	printSummary(engine)
+	return failOn(engine, *fail, nil)
+}
+
+func cmdReindex(args []string) error {
+	fs := flag.NewFlagSet("reindex", flag.ContinueOnError)
+	err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+
+	engine, err := cases().Open(caseName, true)
+	if err != nil {
+		return err
+	}
+	defer engine.Close()
+	ctx, cancel := interruptContext()
+	defer cancel()
+	err = engine.Reindex(ctx, printProgress)
+	fmt.Fprintln(os.Stderr)
+	printStats(engine)
+	if err != nil {
+		return err
+	}
+	drift, err := engine.IndexDrift()
+	if err != nil {
+		return err
+	}
+	fmt.Println(drift)
+	return nil
+}
+
+//searchFlags adds the flags of the search options other than the query and paging
+func searchFlags(fs *flag.FlagSet, opts *analyzer.SearchOptions) {
+	fs.StringVar(&opts.Sort, "sort", analyzer.SortByScore, "sort order, one of "+strings.Join(analyzer.SortOrders, ", "))
+	fs.Var(timeFlag{&opts.Start}, "start", "only events since this time (RFC3339 or YYYY-MM-DD[THH:MM], UTC)")
+	fs.Var(timeFlag{&opts.End}, "end", "only events until this time (RFC3339 or YYYY-MM-DD[THH:MM], UTC)")
+	opts.Filters = make(map[string]string)
+	fs.Var(filtersFlag(opts.Filters), "filter", "only events where field has the term, e.g. eventName=AssumeRole (repeatable)")
+}
+
+func cmdSearch(args []string) error {
+	fs := flag.NewFlagSet("search", flag.ContinueOnError)
+	var opts analyzer.SearchOptions
+	fs.IntVar(&opts.Size, "size", 10, "number of hits to show")
+	fs.IntVar(&opts.From, "from", 0, "offset of the first hit to show")
+	searchFlags(fs, &opts)
+	saved := fs.String("saved", "", "run the saved search with this name instead of a query")
+	facets := fs.Bool("facets", false, "print the top terms of "+strings.Join(analyzer.FacetFields, ", ")+" and a time histogram")
+	asJSON := fs.Bool("json", false, "print results as JSON")
+	err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	opts.Query = strings.Join(fs.Args(), " ")
+	if *saved == "" && opts.MatchesAll() {
+		return errors.New("search: missing query")
+	}
+
+	engine, err := openEngine(true)
+	if err != nil {
+		return err
+	}
+	defer engine.Close()
+	if *saved != "" {
+		s, ok := engine.Searches.Get(*saved)
+		if !ok {
+			return fmt.Errorf("Saved search '%v' not found", *saved)
+		}
+		from, size := opts.From, opts.Size
+		opts, err = s.Options(time.Now())
+		if err != nil {
+			return err
+		}
+		opts.From, opts.Size = from, size
+	}
+	req, err := opts.Request()
+	if err != nil {
+		return err
+	}
+	if *facets {
+		err = engine.Indexer.AddFacets(req, opts)
+		if err != nil {
+			return err
+		}
+	}
+	sr, err := engine.Indexer.Search(req)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		return printJSON(sr)
+	}
+	if len(sr.Hits) == 0 {
+		fmt.Printf("%d matches, took %s\n", sr.Total, sr.Took)
+		return nil
+	}
+	fmt.Printf("%d matches, showing %d through %d, took %s\n", sr.Total, opts.From+1, opts.From+len(sr.Hits), sr.Took)
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSCORE\tTIME\tNAME\tARN\tSOURCE IP")
+	for _, hit := range sr.Hits {
+		fmt.Fprintf(w, "%v\t%.3f\t%v\t%v\t%v\t%v\n", hit.ID, hit.Score,
+			hit.Fields["eventTime"], hit.Fields["eventName"], hit.Fields["userIdentity.arn"], hit.Fields["sourceIPAddress"])
+	}
+	err = w.Flush()
+	if err != nil || !*facets {
+		return err
+	}
+	return printFacets(sr)
+}
+
+//printFacets prints the term facets and the time histogram of a search result
+func printFacets(sr *bleve.SearchResult) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	for _, field := range analyzer.FacetFields {
+		terms := analyzer.Terms(sr, field)
+		if len(terms) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "\n%v\tCOUNT\n", strings.ToUpper(field))
+		for _, t := range terms {
+			fmt.Fprintf(w, "%v\t%v\n", t.Term, t.Count)
+		}
+		if sr.Facets[field].Other > 0 {
+			fmt.Fprintf(w, "(other)\t%v\n", sr.Facets[field].Other)
+		}
+	}
+	buckets := analyzer.Histogram(sr)
+	if len(buckets) > 0 {
+		fmt.Fprintln(w, "\nTIME\tCOUNT")
+		for _, b := range buckets {
+			fmt.Fprintf(w, "%v\t%v\n", b.Start.Format(time.RFC3339), b.Count)
+		}
+	}
+	return w.Flush()
+}
+
+func cmdSave(args []string) error {
+	fs := flag.NewFlagSet("save", flag.ContinueOnError)
+	var opts analyzer.SearchOptions
+	searchFlags(fs, &opts)
+	window := fs.String("window", "", "only events of this period before the search runs, e.g. 24h or 7d")
+	hunt := fs.Bool("hunt", false, "run the search after every load and report its new hits as findings")
+	severity := fs.String("severity", "medium", "severity of hunt findings")
+	err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return errors.New("save: missing name")
+	}
+	s := analyzer.SavedSearch{
+		Name:    fs.Arg(0),
+		Query:   strings.Join(fs.Args()[1:], " "),
+		Window:  *window,
+		Start:   opts.Start,
+		End:     opts.End,
+		Filters: opts.Filters,
+		Sort:    opts.Sort,
+		Hunt:    *hunt,
+	}
+	s.Severity, err = findings.ParseSeverity(*severity)
+	if err != nil {
+		return err
+	}
+	engine, err := openEngine(false)
+	if err != nil {
+		return err
+	}
+	defer engine.Close()
+	err = engine.Searches.Put(s)
+	if err != nil {
+		return err
+	}
+	return engine.Searches.Save()
+}
+
+func cmdSearches(args []string) error {
+	fs := flag.NewFlagSet("searches", flag.ContinueOnError)
+	remove := fs.String("delete", "", "delete the saved search with this name")
+	asJSON := fs.Bool("json", false, "print saved searches as JSON")
+	err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+
+	engine, err := openEngine(false)
+	if err != nil {
+		return err
+	}
+	defer engine.Close()
+	if *remove != "" {
+		err = engine.Searches.Delete(*remove)
+		if err != nil {
+			return err
+		}
+		return engine.Searches.Save()
+	}
+	list := engine.Searches.List()
+	if *asJSON {
+		return printJSON(list)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tHUNT\tWINDOW\tLAST RUN\tHITS\tNEW\tQUERY")
+	for _, s := range list {
+		run, _ := s.LastRun()
+		hunt := ""
+		if s.Hunt {
+			hunt = s.Severity.String()
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\n", s.Name, hunt, s.Window, runTime(run), run.Hits, run.New, s.Query)
+	}
+	return w.Flush()
+}
+
+//runTime formats the time of a hunt run, empty if the hunt never ran
+func runTime(run analyzer.HuntRun) string {
+	if run.Time.IsZero() {
+		return ""
+	}
+	return run.Time.Format(time.RFC3339)
+}
+
+func cmdHunt(args []string) error {
+	fs := flag.NewFlagSet("hunt", flag.ContinueOnError)
+	fail := fs.String("fail-on", "", "exit with code 2 if findings at or above this severity are produced")
+	err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+
+	engine, err := openEngine(true)
+	if err != nil {
+		return err
+	}
+	defer engine.Close()
+	ctx, cancel := interruptContext()
+	defer cancel()
+	before := findingIDs(engine)
+	err = engine.RunHunts(ctx)
+	if err != nil {
+		return err
+	}
+	err = engine.Save()
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "HUNT\tHITS\tNEW\tERROR")
+	for _, s := range engine.Searches.List() {
+		if run, ok := s.LastRun(); ok && s.Hunt {
+			fmt.Fprintf(w, "%v\t%v\t%v\t%v\n", s.Name, run.Hits, run.New, run.Error)
+		}
+	}
+	err = w.Flush()
+	if err != nil {
+		return err
+	}
+	return failOn(engine, *fail, before)
+}
+
+//sessionSummary is the printed form of an assume role session
+type sessionSummary struct {
+	Time           string
+	Name           string
+	AssumedRoleARN string
+	Events         int
+	Findings       int
+}
+
+func cmdSessions(args []string) error {
+	fs := flag.NewFlagSet("sessions", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "print sessions as JSON")
+	err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+
+	engine, err := openEngine(false)
+	if err != nil {
+		return err
+	}
+	defer engine.Close()
+	list := make([]sessionSummary, 0)
+	for _, sess := range engine.Sessions.List() {
+		list = append(list, sessionSummary{
+			Time:           sess.Time(),
+			Name:           sess.Name,
+			AssumedRoleARN: sess.AssumedRoleARN,
+			Events:         len(sess.Events),
+			Findings:       len(engine.Findings.Find(findings.Filter{SessionARN: sess.AssumedRoleARN})),
+		})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Time < list[j].Time })
+	if *asJSON {
+		return printJSON(list)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tNAME\tARN\tEVENTS\tFINDINGS")
+	for _, s := range list {
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\n", s.Time, s.Name, s.AssumedRoleARN, s.Events, s.Findings)
+	}
+	return w.Flush()
+}
+
+func cmdFindings(args []string) error {
+	fs := flag.NewFlagSet("findings", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "print findings as JSON")
+	min := fs.String("min", "info", "only list findings at or above this severity")
+	rule := fs.String("rule", "", "only list findings of this rule")
+	principal := fs.String("principal", "", "only list findings about this principal ARN")
+	byTime := fs.Bool("by-time", false, "sort by first seen time instead of severity")
+	fail := fs.String("fail-on", "", "exit with code 2 if open findings at or above this severity exist")
+	err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+
+	filter := findings.Filter{RuleID: *rule, Principal: *principal}
+	filter.MinSeverity, err = findings.ParseSeverity(*min)
+	if err != nil {
+		return err
+	}
+	engine, err := openEngine(false)
+	if err != nil {
+		return err
+	}
+	defer engine.Close()
+	list := engine.Findings.Find(filter)
+	if *byTime {
+		sort.Sort(findings.ByTime(list))
+	}
+	if *asJSON {
+		err = printJSON(list)
+	} else {
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tSEVERITY\tSTATUS\tRULE\tFIRST SEEN\tLAST SEEN\tEVENTS\tPRINCIPAL\tTITLE")
+		for _, f := range list {
+			fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n", f.ID, f.Severity, f.Status, f.RuleID, f.FirstSeen, f.LastSeen, len(f.EventIDs), f.Principal, f.Title)
+		}
+		err = w.Flush()
+	}
+	if err != nil {
+		return err
+	}
+	return failOn(engine, *fail, nil)
+}
+
+func cmdCases(args []string) error {
+	fs := flag.NewFlagSet("cases", flag.ContinueOnError)
+	create := fs.String("new", "", "create an empty case with this name")
+	remove := fs.String("delete", "", "delete the case with this name and all of its files")
+	asJSON := fs.Bool("json", false, "print cases as JSON")
+	err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+
+	c := cases()
+	if *create != "" {
+		return c.Create(*create)
+	}
+	if *remove != "" {
+		return c.Delete(*remove)
+	}
+	names, err := c.List()
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		return printJSON(names)
+	}
+	for _, name := range names {
+		current := " "
+		if name == caseName {
+			current = "*"
+		}
+		fmt.Printf("%v %v\n", current, name)
+	}
+	return nil
+}
+
+func cmdExtract(args []string) error {
+	fs := flag.NewFlagSet("extract", flag.ContinueOnError)
+	var opts analyzer.SearchOptions
+	searchFlags(fs, &opts)
+	saved := fs.String("saved", "", "extract the hits of the saved search with this name instead of a query")
+	err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return errors.New("extract: missing case name")
+	}
+	name := fs.Arg(0)
+	opts.Query = strings.Join(fs.Args()[1:], " ")
+	if *saved == "" && opts.MatchesAll() {
+		return errors.New("extract: missing query")
+	}
+
+	engine, err := openEngine(true)
+	if err != nil {
+		return err
+	}
+	defer engine.Close()
+	if *saved != "" {
+		s, ok := engine.Searches.Get(*saved)
+		if !ok {
+			return fmt.Errorf("Saved search '%v' not found", *saved)
+		}
+		opts, err = s.Options(time.Now())
+		if err != nil {
+			return err
+		}
+	}
+	ctx, cancel := interruptContext()
+	defer cancel()
+	copied, err := cases().CreateFrom(ctx, name, engine, opts, printProgress)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%v events copied to case '%v'\n", copied, name)
+	return nil
+}
+
+func cmdExport(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	output := fs.String("o", "", "archive to write (default <case>.korra.tgz)")
+	err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if *output == "" {
+		*output = caseName + ".korra.tgz"
+	}
+
+	engine, err := openEngine(false)
+	if err != nil {
+		return err
+	}
+	defer engine.Close()
+	f, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	err = engine.Export(f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Case '%v' exported to %v\n", caseName, *output)
+	return nil
+}
+
+func cmdImport(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		return errors.New("import: expected an archive and a case name")
+	}
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	ctx, cancel := interruptContext()
+	defer cancel()
+	err = cases().Import(ctx, f, fs.Arg(1), printProgress)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Case '%v' imported, use -case %v to work on it\n", fs.Arg(1), fs.Arg(1))
+	return nil
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/dtylman/korra/analyzer"
+	"github.com/dtylman/korra/analyzer/cloudtrail"
+	"github.com/dtylman/korra/analyzer/findings"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunCommand_AnalyzeFailOn(t *testing.T) {
+	dir, err := ioutil.TempDir("", "korra")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	require.NoError(t, openDataDir(dir))
+
+	engine, err := cases().Open(caseName, true)
+	require.NoError(t, err)
+	engine.Events.AddEvent(cloudtrail.Event{ID: "1", Name: "AssumeRole", Time: time.Now()})
+	require.NoError(t, engine.Reindex(context.Background(), nil))
+	require.NoError(t, engine.Searches.Put(analyzer.SavedSearch{Name: "roles", Query: "eventName:AssumeRole", Hunt: true, Severity: findings.SeverityHigh}))
+	require.NoError(t, engine.Save())
+	require.NoError(t, engine.Close())
+
+	//every analysis judges all the findings, not only the ones it added
+	assert.EqualValues(t, exitFindings, runCommand([]string{"analyze", "-fail-on", "high"}))
+	assert.EqualValues(t, exitFindings, runCommand([]string{"analyze", "-fail-on", "high"}))
+	assert.EqualValues(t, exitOK, runCommand([]string{"analyze", "-fail-on", "critical"}))
+	assert.EqualValues(t, exitFindings, runCommand([]string{"findings", "-fail-on", "high"}))
+	assert.EqualValues(t, exitError, runCommand([]string{"analyze", "-bogus"}))
+}
@@ -1,6 +1,35 @@
 package main
 
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/dtylman/korra/analyzer"
+)
+
 func main() {
+	dir := flag.String("data", "", "data directory holding events, search index, findings and saved searches "+
+		"(default $"+dataDirEnv+" or $XDG_DATA_HOME/korra)")
+	flag.StringVar(&caseName, "case", analyzer.DefaultCase, "case (investigation) to work on, see the cases command")
+	flag.CommandLine.Init(os.Args[0], flag.ContinueOnError)
+	flag.Usage = usage
+	err := flag.CommandLine.Parse(os.Args[1:])
+	if err == flag.ErrHelp {
+		os.Exit(exitOK)
+	}
+	if err != nil {
+		os.Exit(exitError)
+	}
+	err = openDataDir(*dir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitError)
+	}
+	if flag.NArg() > 0 {
+		os.Exit(runCommand(flag.Args()))
+	}
+
 	a, err := newApp()
 	if err != nil {
 		panic(err)
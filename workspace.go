@@ -0,0 +1,141 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/dtylman/korra/analyzer"
+)
+
+//templates are the HTML templates of the UI, embedded so korra runs from any directory
+//
+//go:embed *.html
+var templates embed.FS
+
+//dataDirEnv is the environment variable setting the data directory
+const dataDirEnv = "KORRA_DATA"
+
+//configFile is the file in the data directory keeping the settings
+const configFile = "korra.config.json"
+
+//dataDir is the directory holding the cases, each with its events, search index, findings,
+//checkpoints and saved searches
+var dataDir string
+
+//caseName is the case commands work on and the UI opens first
+var caseName = analyzer.DefaultCase
+
+//defaultDataDir returns $KORRA_DATA, or korra under $XDG_DATA_HOME (~/.local/share/korra if not set)
+func defaultDataDir() (string, error) {
+	dir := os.Getenv(dataDirEnv)
+	if dir != "" {
+		return dir, nil
+	}
+	base := os.Getenv("XDG_DATA_HOME")
+	if base == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", errors.New("Cannot find the data directory, set -data or " + dataDirEnv)
+		}
+		base = filepath.Join(home, ".local", "share")
+	}
+	return filepath.Join(base, "korra"), nil
+}
+
+//openDataDir sets dataDir to dir, or the default data directory if dir is empty, and creates it
+func openDataDir(dir string) error {
+	var err error
+	if dir == "" {
+		dir, err = defaultDataDir()
+		if err != nil {
+			return err
+		}
+		for _, name := range []string{"korra.events.db", "korra.events.json"} {
+			if fileExists(name) {
+				fmt.Fprintf(os.Stderr, "%v in the current directory is not used, run with -data . "+
+					"to use it or move it to %v\n", name, dir)
+			}
+		}
+	}
+	err = os.MkdirAll(dir, 0700)
+	if err != nil {
+		return err
+	}
+	dataDir = dir
+	return nil
+}
+
+//cases returns the cases of the data directory
+func cases() *analyzer.Cases {
+	return analyzer.NewCases(dataDir)
+}
+
+func fileExists(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
+
+//config holds the settings kept in the data directory, the load form of the UI as it was last used.
+//Access keys, secrets, session tokens and MFA codes are never kept.
+type config struct {
+	Region            string `json:"region,omitempty"`
+	MaxEvents         string `json:"maxEvents,omitempty"`
+	RequestsPerSecond string `json:"requestsPerSecond,omitempty"`
+	Regions           string `json:"regions,omitempty"`
+	Accounts          string `json:"accounts,omitempty"`
+	AuditRole         string `json:"auditRole,omitempty"`
+	Profile           string `json:"profile,omitempty"`
+	Endpoint          string `json:"endpoint,omitempty"`
+	RoleARN           string `json:"roleArn,omitempty"`
+	ExternalID        string `json:"externalId,omitempty"`
+	MFASerial         string `json:"mfaSerial,omitempty"`
+	Bucket            string `json:"bucket,omitempty"`
+	Prefix            string `json:"prefix,omitempty"`
+}
+
+//inputs maps the IDs of the load form inputs to the settings
+func (c *config) inputs() map[string]*string {
+	return map[string]*string{
+		"input-region":     &c.Region,
+		"input-maxevents":  &c.MaxEvents,
+		"input-rps":        &c.RequestsPerSecond,
+		"input-regions":    &c.Regions,
+		"input-accounts":   &c.Accounts,
+		"input-auditrole":  &c.AuditRole,
+		"input-profile":    &c.Profile,
+		"input-endpoint":   &c.Endpoint,
+		"input-rolearn":    &c.RoleARN,
+		"input-externalid": &c.ExternalID,
+		"input-mfaserial":  &c.MFASerial,
+		"input-bucket":     &c.Bucket,
+		"input-prefix":     &c.Prefix,
+	}
+}
+
+//loadConfig reads the settings of the data directory, empty if they were never saved
+func loadConfig() (config, error) {
+	var c config
+	data, err := ioutil.ReadFile(filepath.Join(dataDir, configFile))
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return c, err
+	}
+	err = json.Unmarshal(data, &c)
+	return c, err
+}
+
+//save writes the settings to the data directory
+func (c config) save() error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dataDir, configFile), data, 0600)
+}